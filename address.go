@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+// addrStrategies are the ways a node's connection address can be
+// looked up.  iface:<name> is also accepted but isn't part of the
+// 'a' key rotation.
+var (
+	addrStrategies = []string{"name", "fqdn", "ip", "ec2"}
+	addrStrategy   = "name"
+)
+
+func setAddrStrategy(s string) error {
+	if s == "" {
+		return nil
+	}
+	if strings.HasPrefix(s, "iface:") && len(s) > len("iface:") {
+		addrStrategy = s
+		return nil
+	}
+	for _, x := range addrStrategies {
+		if x == s {
+			addrStrategy = s
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown address %s, choose from %s or iface:<name>", s, strings.Join(addrStrategies, ", "))
+}
+
+// address returns the host to connect to for n, falling back to the
+// node name when the node doesn't have the attribute the strategy needs.
+func address(n node) string {
+	var a string
+	switch {
+	case addrStrategy == "fqdn":
+		a = n.node.Info.FQDN
+	case addrStrategy == "ip":
+		a = n.node.Info.IPAddress
+	case addrStrategy == "ec2":
+		if ip, ok := n.node.Info.EC2["public_ipv4"].(string); ok {
+			a = ip
+		}
+	case strings.HasPrefix(addrStrategy, "iface:"):
+		a = ifaceAddress(n, strings.TrimPrefix(addrStrategy, "iface:"))
+	}
+	if a == "" {
+		return n.node.Name
+	}
+	return a
+}
+
+func ifaceAddress(n node, name string) string {
+	iface, ok := n.node.Info.Network.Interfaces[name]
+	if !ok {
+		return ""
+	}
+	for addr, a := range iface.Addresses {
+		if a.Family == "inet" {
+			return addr
+		}
+	}
+	return ""
+}
+
+func toggleAddress(g *ui.Gui, v *ui.View) error {
+	i := 0
+	for j, x := range addrStrategies {
+		if x == addrStrategy {
+			i = (j + 1) % len(addrStrategies)
+		}
+	}
+	addrStrategy = addrStrategies[i]
+	cv, _ := g.View("hosts-cursor")
+	_, cur := cv.Cursor()
+	if cur < len(visibleNodes) {
+		msg <- fmt.Sprintf("connecting by %s (%s)", addrStrategy, address(visibleNodes[cur]))
+	} else {
+		msg <- fmt.Sprintf("connecting by %s", addrStrategy)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// alias is a saved search, ussh @name expands into its flags and
+// query.
+type alias struct {
+	Query   string `json:"query"`
+	Knife   string `json:"knife"`
+	Role    string `json:"role"`
+	Filter  string `json:"filter"`
+	Profile string `json:"profile"`
+}
+
+func (a alias) args() []string {
+	var out []string
+	for _, f := range []struct{ name, val string }{
+		{"--knife", a.Knife},
+		{"--role", a.Role},
+		{"--filter", a.Filter},
+		{"--profile", a.Profile},
+	} {
+		if f.val != "" {
+			out = append(out, fmt.Sprintf("%s=%s", f.name, f.val))
+		}
+	}
+	if a.Query != "" {
+		out = append(out, a.Query)
+	}
+	return out
+}
+
+// expandAliases replaces each @name on the command line with the
+// alias' flags.  Flags that come after it on the command line win.
+func expandAliases(args []string) ([]string, error) {
+	var out []string
+	for _, a := range args {
+		if !strings.HasPrefix(a, "@") {
+			out = append(out, a)
+			continue
+		}
+		al, ok := cfg.Aliases[a[1:]]
+		if !ok {
+			return nil, fmt.Errorf("no alias named %s in %s", a[1:], configPath())
+		}
+		out = append(out, al.args()...)
+	}
+	return out, nil
+}
+
+func aliasNames() []string {
+	var out []string
+	for k := range cfg.Aliases {
+		out = append(out, "@"+k)
+	}
+	sort.Strings(out)
+	return out
+}
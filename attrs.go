@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// attributes merges a node's attributes the way chef does, automatic
+// beats override beats normal beats default.
+func attributes(n node) map[string]interface{} {
+	doc := nodeJSON(n)
+	out := map[string]interface{}{}
+	for _, k := range []string{"default", "normal", "override", "automatic"} {
+		if m, ok := doc[k].(map[string]interface{}); ok {
+			merge(out, m)
+		}
+	}
+	return out
+}
+
+// nodeJSON is the whole node as chef returned it.  The mock nodes
+// don't have the raw json so theirs is rebuilt from the chef.Node.
+func nodeJSON(n node) map[string]interface{} {
+	raw := n.raw
+	if len(raw) == 0 {
+		raw, _ = json.Marshal(n.node)
+	}
+	doc := map[string]interface{}{}
+	json.Unmarshal(raw, &doc)
+	return doc
+}
+
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if ok && dok {
+			merge(dm, sm)
+			continue
+		}
+		if ok {
+			m := map[string]interface{}{}
+			merge(m, sm)
+			v = m
+		}
+		dst[k] = v
+	}
+}
+
+// lookup finds a dotted attribute path such as kernel.release.
+func lookup(attrs map[string]interface{}, pth string) (interface{}, bool) {
+	var cur interface{} = attrs
+	for _, k := range strings.Split(pth, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+var (
+	browseKind  string
+	browseNames []string
+
+	detailLock sync.Mutex
+	details    = map[string]string{}
+)
+
+// chefGet decodes the json at a chef api endpoint into v.
+func chefGet(endpoint string, v interface{}) error {
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// chefRole and chefEnvironment have the fields the chef-golang types
+// leave out.
+type chefRole struct {
+	Description string              `json:"description"`
+	RunList     []string            `json:"run_list"`
+	EnvRunLists map[string][]string `json:"env_run_lists"`
+}
+
+type chefEnvironment struct {
+	Description      string            `json:"description"`
+	CookbookVersions map[string]string `json:"cookbook_versions"`
+}
+
+func browseRoles(g *ui.Gui, v *ui.View) error {
+	return browse(g, "roles")
+}
+
+func browseEnvironments(g *ui.Gui, v *ui.View) error {
+	return browse(g, "environments")
+}
+
+// browse lists the chef server's roles or environments next to the
+// host list with the highlighted one's details below.
+func browse(g *ui.Gui, kind string) error {
+	if client == nil {
+		msg <- fmt.Sprintf("%s aren't available without a chef server", kind)
+		return nil
+	}
+
+	x, y := g.Size()
+	bv, err := g.SetView("browse", getWidth()+13, 0, x, y/2)
+	if err != nil {
+		if err != ui.ErrUnknownView {
+			return err
+		}
+		bv.Highlight = true
+	}
+	dv, err := g.SetView("browse-detail", getWidth()+13, y/2, x, y-1)
+	if err != nil && err != ui.ErrUnknownView {
+		return err
+	}
+	bv.Title = fmt.Sprintf("%s (enter: show nodes, q: close)", kind)
+	bv.Clear()
+	dv.Clear()
+	bv.SetCursor(0, 0)
+	bv.SetOrigin(0, 0)
+	fmt.Fprintln(bv, "loading...")
+	browseKind = kind
+	browseNames = nil
+	current = "browse"
+
+	go func() {
+		var names map[string]string
+		var err error
+		if kind == "roles" {
+			names, err = client.GetRoles()
+		} else {
+			names, err = client.GetEnvironments()
+		}
+		g.Execute(func(g *ui.Gui) error {
+			bv, verr := g.View("browse")
+			if verr != nil || browseKind != kind {
+				return nil
+			}
+			bv.Clear()
+			if err != nil {
+				fmt.Fprintf(bv, "couldn't get the %s: %s\n", kind, err)
+				return nil
+			}
+			browseNames = nil
+			for k := range names {
+				browseNames = append(browseNames, k)
+			}
+			sort.Strings(browseNames)
+			for _, k := range browseNames {
+				fmt.Fprintln(bv, k)
+			}
+			showDetail(g, bv)
+			return nil
+		})
+	}()
+	return g.SetCurrentView("browse")
+}
+
+func currentBrowse(v *ui.View) string {
+	_, cy := v.Cursor()
+	_, oy := v.Origin()
+	if cy+oy >= len(browseNames) {
+		return ""
+	}
+	return browseNames[cy+oy]
+}
+
+// showDetail prints the description and run list of the highlighted
+// role or environment, fetching it if it hasn't been seen yet.
+func showDetail(g *ui.Gui, v *ui.View) {
+	name := currentBrowse(v)
+	if name == "" {
+		return
+	}
+	key := browseKind + "/" + name
+	detailLock.Lock()
+	d, ok := details[key]
+	detailLock.Unlock()
+	if ok {
+		printDetail(g, d)
+		return
+	}
+
+	printDetail(g, "loading...")
+	kind := browseKind
+	go func() {
+		d := fetchDetail(kind, name)
+		detailLock.Lock()
+		details[key] = d
+		detailLock.Unlock()
+		g.Execute(func(g *ui.Gui) error {
+			if bv, err := g.View("browse"); err == nil && browseKind+"/"+currentBrowse(bv) == key {
+				printDetail(g, d)
+			}
+			return nil
+		})
+	}()
+}
+
+func fetchDetail(kind, name string) string {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, name)
+	if kind == "roles" {
+		var r chefRole
+		if err := chefGet("roles/"+name, &r); err != nil {
+			return err.Error()
+		}
+		fmt.Fprintf(&buf, "  %s\n\nrun list:\n", r.Description)
+		for _, x := range r.RunList {
+			fmt.Fprintf(&buf, "  %s\n", x)
+		}
+		for _, env := range sortedKeys(r.EnvRunLists) {
+			fmt.Fprintf(&buf, "\nrun list in %s:\n", env)
+			for _, x := range r.EnvRunLists[env] {
+				fmt.Fprintf(&buf, "  %s\n", x)
+			}
+		}
+		return buf.String()
+	}
+
+	var e chefEnvironment
+	if err := chefGet("environments/"+name, &e); err != nil {
+		return err.Error()
+	}
+	fmt.Fprintf(&buf, "  %s\n\ncookbook versions:\n", e.Description)
+	var cbs []string
+	for cb := range e.CookbookVersions {
+		cbs = append(cbs, cb)
+	}
+	sort.Strings(cbs)
+	for _, cb := range cbs {
+		fmt.Fprintf(&buf, "  %s %s\n", cb, e.CookbookVersions[cb])
+	}
+	return buf.String()
+}
+
+func sortedKeys(m map[string][]string) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func printDetail(g *ui.Gui, s string) {
+	dv, err := g.View("browse-detail")
+	if err != nil {
+		return
+	}
+	dv.Clear()
+	fmt.Fprint(dv, s)
+}
+
+// browseMove moves the cursor, scrolling when there are more roles
+// or environments than fit.
+func browseMove(d int) ui.KeybindingHandler {
+	return func(g *ui.Gui, v *ui.View) error {
+		_, cy := v.Cursor()
+		_, oy := v.Origin()
+		_, h := v.Size()
+		i := cy + oy + d
+		if i >= len(browseNames) {
+			i = len(browseNames) - 1
+		}
+		if i < 0 {
+			i = 0
+		}
+		if i < oy {
+			oy = i
+		} else if i >= oy+h {
+			oy = i - h + 1
+		}
+		if err := v.SetOrigin(0, oy); err != nil {
+			return err
+		}
+		if err := v.SetCursor(0, i-oy); err != nil {
+			return err
+		}
+		showDetail(g, v)
+		return nil
+	}
+}
+
+// drill replaces the hosts with the nodes in the highlighted role or
+// environment.
+func drill(g *ui.Gui, v *ui.View) error {
+	name := currentBrowse(v)
+	if name == "" {
+		return nil
+	}
+	q := "role:" + name
+	if browseKind == "environments" {
+		q = "chef_environment:" + name
+	}
+	if err := exitBrowse(g, v); err != nil {
+		return err
+	}
+
+	go func() {
+		msg <- fmt.Sprintf("searching for %s", q)
+		found, err := searchNodes(q)
+		g.Execute(func(g *ui.Gui) error {
+			if err != nil {
+				go func() { msg <- fmt.Sprintf("search failed: %s", err) }()
+				return nil
+			}
+			if len(found) == 0 {
+				go func() { msg <- fmt.Sprintf("no nodes found with %s", q) }()
+				return nil
+			}
+			hosts = found
+			sortHosts()
+			if err := updateCache(hosts); err != nil {
+				log.Println("couldn't update the completion cache", err)
+			}
+			fv, _ := g.View("filter")
+			search(strings.TrimSpace(fv.Buffer()))
+			cv, _ := g.View("hosts-cursor")
+			cv.SetCursor(0, 0)
+			printNodes()
+			go func() { msg <- fmt.Sprintf("%d node(s) with %s", len(found), q) }()
+			return nil
+		})
+	}()
+	return nil
+}
+
+func exitBrowse(g *ui.Gui, v *ui.View) error {
+	current = "hosts-cursor"
+	browseKind = ""
+	g.DeleteView("browse-detail")
+	return g.DeleteView("browse")
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/marpaia/chef-golang"
+)
+
+const (
+	// chefClientVersion is sent as X-Chef-Version, chef-golang's
+	// 11.6.0 is older than some servers accept.
+	chefClientVersion  = "12.0.0"
+	defaultAPIVersion  = "1"
+	defaultSignVersion = "1.3"
+
+	// defaultChefTimeout is how long to wait for the chef server to
+	// answer, searches of big organizations can take a while.
+	defaultChefTimeout = 60 * time.Second
+	chefDialTimeout    = 10 * time.Second
+	chefTries          = 4
+	chefBackoff        = 500 * time.Millisecond
+	maxRetryAfter      = 10 * time.Second
+)
+
+// signTime is when requests are signed, tests replace it.
+var signTime = time.Now
+
+// chefTransport signs requests to the chef server the way the config
+// asks, chef-golang only knows protocol 1.0.  Unless they're pinned in
+// the config it falls back to 1.0 signing for servers that reject 1.3
+// and to the api version the server says it supports.  Requests that
+// fail on the way to the server are retried.
+type chefTransport struct {
+	base http.RoundTripper
+	chef *chef.Chef
+	host string
+
+	lock       sync.Mutex
+	sign       string
+	signFixed  bool
+	apiVersion string
+	apiFixed   bool
+}
+
+// setupChef applies the config's chef server settings, and the ones
+// in knifeRB chef-golang doesn't know about, to c and installs the
+// signing transport.  chef-golang uses the default transport when
+// SSLNoVerify is off.
+func setupChef(c *chef.Chef, knifeRB string) error {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return fmt.Errorf("invalid chef_server_url %s: %s", c.Url, err)
+	}
+	if org := cfg.Organization; org != "" {
+		if c.Organization == "" {
+			c.Url = strings.TrimRight(c.Url, "/") + "/organizations/" + org
+			c.Organization = org
+		} else if c.Organization != org {
+			return fmt.Errorf("chef_server_url %s is for organization %s, not %s", c.Url, c.Organization, org)
+		}
+	}
+	c.Version = chefClientVersion
+	c.SSLNoVerify = false
+
+	knife := knifeSettings(knifeRB)
+	if err := exportProxies(knifeRB, knife); err != nil {
+		return err
+	}
+	tc, err := tlsConfig(knifeRB, knife)
+	if err != nil {
+		return fmt.Errorf("couldn't load the chef server's ca certificates: %s", err)
+	}
+	t := &chefTransport{
+		base: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: chefDialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+			TLSClientConfig:       tc,
+			TLSHandshakeTimeout:   chefDialTimeout,
+			ResponseHeaderTimeout: cfg.chefTimeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		chef:       c,
+		host:       u.Host,
+		sign:       defaultSignVersion,
+		apiVersion: defaultAPIVersion,
+	}
+	if cfg.SignVersion != "" {
+		t.sign, t.signFixed = cfg.SignVersion, true
+	}
+	if cfg.ServerAPIVersion != "" {
+		t.apiVersion, t.apiFixed = cfg.ServerAPIVersion, true
+	}
+	http.DefaultTransport = t
+	return nil
+}
+
+func (t *chefTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	t.lock.Lock()
+	sign, api := t.sign, t.apiVersion
+	t.lock.Unlock()
+
+	var triedAPI, triedSign bool
+	for {
+		resp, err := t.send(req, body, sign, api)
+		if err != nil {
+			return nil, certError(err)
+		}
+
+		switch resp.StatusCode {
+		case http.StatusNotAcceptable:
+			max := maxAPIVersion(resp)
+			if t.apiFixed || triedAPI || max == "" || max == api {
+				return nil, t.apiError(resp, api)
+			}
+			resp.Body.Close()
+			triedAPI, api = true, max
+			continue
+		case http.StatusUnauthorized:
+			if t.signFixed || triedSign || sign == "1.0" {
+				return nil, t.authError(resp, sign)
+			}
+			resp.Body.Close()
+			triedSign, sign = true, "1.0"
+			continue
+		}
+
+		t.lock.Lock()
+		t.sign, t.apiVersion = sign, api
+		t.lock.Unlock()
+		return resp, nil
+	}
+}
+
+// send tries a request a few times, backing off between tries, while
+// it fails with a network error or the server (or a proxy in front of
+// it) says it's unavailable.  Only requests that don't change anything
+// are retried.
+func (t *chefTransport) send(req *http.Request, body []byte, sign, api string) (*http.Response, error) {
+	retry := req.Method == "GET" || req.Method == "HEAD"
+	wait := chefBackoff
+	for try := 1; ; try++ {
+		resp, err := t.base.RoundTrip(t.signed(req, body, sign, api))
+		if !retry || try == chefTries {
+			if err != nil && retry {
+				err = fmt.Errorf("gave up after %d tries: %s", try, err)
+			}
+			return resp, err
+		}
+
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				if d := retryAfter(resp); d > wait {
+					wait = d
+				}
+				log.Printf("chef server said %s for %s, trying again in %s", resp.Status, req.URL.Path, wait)
+				resp.Body.Close()
+			default:
+				return resp, nil
+			}
+		} else if transient(err) {
+			log.Printf("%s, trying again in %s", err, wait)
+		} else {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		wait *= 2
+	}
+}
+
+// transient errors are worth another try: timeouts, refused or reset
+// connections and connections closed before the server answered.
+func transient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter is how long a 429 or 503 asks to wait, in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	n, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	d := time.Duration(n) * time.Second
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d
+}
+
+// signed copies the request with the headers for the signing
+// protocol.  chef-golang has already signed it with 1.0.
+func (t *chefTransport) signed(req *http.Request, body []byte, sign, api string) *http.Request {
+	r := req.Clone(req.Context())
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	r.Header.Set("X-Ops-Server-API-Version", api)
+	if sign == "1.0" {
+		return r
+	}
+
+	for k := range r.Header {
+		if strings.HasPrefix(k, "X-Ops-Authorization-") {
+			r.Header.Del(k)
+		}
+	}
+	r.Header.Del("Hashed-Path")
+	r.Header.Del("Method")
+
+	hash := sha256.Sum256(body)
+	contentHash := base64.StdEncoding.EncodeToString(hash[:])
+	timestamp := signTime().UTC().Format(time.RFC3339)
+	r.Header.Set("X-Ops-Sign", "version="+sign)
+	r.Header.Set("X-Ops-Content-Hash", contentHash)
+	r.Header.Set("X-Ops-Timestamp", timestamp)
+	r.Header.Set("X-Ops-Userid", t.chef.UserId)
+
+	canonical := canonicalRequest(r.Method, r.URL.Path, contentHash, sign, timestamp, t.chef.UserId, api)
+	digest := sha256.Sum256([]byte(canonical))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, t.chef.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		// the server will reject it and authError says why
+		return r
+	}
+	auth := base64.StdEncoding.EncodeToString(sig)
+	for i := 0; len(auth) > 0; i++ {
+		n := 60
+		if n > len(auth) {
+			n = len(auth)
+		}
+		r.Header.Set(fmt.Sprintf("X-Ops-Authorization-%d", i+1), auth[:n])
+		auth = auth[n:]
+	}
+	return r
+}
+
+// certError explains what to do about a certificate the chef server
+// sent that can't be verified.
+func certError(err error) error {
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var host x509.HostnameError
+	if errors.As(err, &unknown) || errors.As(err, &invalid) || errors.As(err, &host) {
+		return fmt.Errorf("%s.  Add the server's ca certificate to knife's trusted_certs_dir (knife ssl fetch does this) or ca_bundle in %s, or use --insecure", err, configPath())
+	}
+	return err
+}
+
+// canonicalRequest is what gets signed in protocol 1.3.
+func canonicalRequest(method, path, contentHash, sign, timestamp, userID, api string) string {
+	return strings.Join([]string{
+		"Method:" + method,
+		"Path:" + path,
+		"X-Ops-Content-Hash:" + contentHash,
+		"X-Ops-Sign:version=" + sign,
+		"X-Ops-Timestamp:" + timestamp,
+		"X-Ops-UserId:" + userID,
+		"X-Ops-Server-API-Version:" + api,
+	}, "\n")
+}
+
+// chefReply is how the chef server explains an error.  error is a
+// string or a list of strings depending on the server.
+type chefReply struct {
+	Error      interface{} `json:"error"`
+	Message    string      `json:"message"`
+	MinVersion interface{} `json:"min_version"`
+	MaxVersion interface{} `json:"max_version"`
+}
+
+func readReply(resp *http.Response) chefReply {
+	defer resp.Body.Close()
+	var r chefReply
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		json.Unmarshal(body, &r)
+	}
+	return r
+}
+
+func (r chefReply) String() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	switch e := r.Error.(type) {
+	case string:
+		return e
+	case []interface{}:
+		var s []string
+		for _, x := range e {
+			s = append(s, fmt.Sprint(x))
+		}
+		return strings.Join(s, ", ")
+	}
+	return ""
+}
+
+// maxAPIVersion is the newest api version the server supports, from
+// the X-Ops-Server-API-Version header of a 406.
+func maxAPIVersion(resp *http.Response) string {
+	var v struct {
+		MaxVersion interface{} `json:"max_version"`
+	}
+	if err := json.Unmarshal([]byte(resp.Header.Get("X-Ops-Server-API-Version")), &v); err != nil || v.MaxVersion == nil {
+		return ""
+	}
+	return fmt.Sprint(v.MaxVersion)
+}
+
+func (t *chefTransport) apiError(resp *http.Response, api string) error {
+	r := readReply(resp)
+	err := fmt.Sprintf("the chef server at %s doesn't accept server api version %s", t.host, api)
+	if r.MinVersion != nil && r.MaxVersion != nil {
+		err += fmt.Sprintf(", it supports %v to %v", r.MinVersion, r.MaxVersion)
+	}
+	if t.apiFixed {
+		err += fmt.Sprintf(", change server_api_version in %s", configPath())
+	}
+	return fmt.Errorf("%s", err)
+}
+
+func (t *chefTransport) authError(resp *http.Response, sign string) error {
+	err := fmt.Sprintf("the chef server at %s rejected the request from %s signed with protocol %s", t.host, t.chef.UserId, sign)
+	if s := readReply(resp).String(); s != "" {
+		err += ": " + s
+	}
+	err += ".  Check node_name and client_key in your knife.rb"
+	if t.signFixed {
+		err += fmt.Sprintf(" and sign_version in %s", configPath())
+	}
+	return fmt.Errorf("%s", err)
+}
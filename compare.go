@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+// diffRow is one line of the comparison, left and right are what goes
+// in each half of the screen.
+type diffRow struct {
+	left, right string
+	header      bool
+	same        bool
+}
+
+func compareNodes(a, b node) []diffRow {
+	aa, ba := attributes(a), attributes(b)
+	ad, bd := nodeJSON(a), nodeJSON(b)
+
+	var rows []diffRow
+	rows = append(rows, diffRow{left: "run list", right: "run list", header: true})
+	rows = append(rows, listDiff(stringList(ad["run_list"]), stringList(bd["run_list"]))...)
+	rows = append(rows, diffRow{left: "roles", right: "roles", header: true})
+	rows = append(rows, listDiff(a.node.Info.Roles, b.node.Info.Roles)...)
+
+	rows = append(rows, diffRow{left: "platform", right: "platform", header: true})
+	for _, k := range []string{"platform", "platform_version", "kernel.release", "kernel.version"} {
+		l, _ := lookup(aa, k)
+		r, _ := lookup(ba, k)
+		rows = append(rows, valueRow(k, attrValue(l), attrValue(r)))
+	}
+
+	rows = append(rows, diffRow{left: "packages", right: "packages", header: true})
+	rows = append(rows, mapDiff(packages(aa), packages(ba))...)
+
+	for _, k := range []string{"normal", "override"} {
+		h := k + " attributes"
+		rows = append(rows, diffRow{left: h, right: h, header: true})
+		l, r := map[string]string{}, map[string]string{}
+		flatten("", ad[k], l)
+		flatten("", bd[k], r)
+		rows = append(rows, mapDiff(l, r)...)
+	}
+	return rows
+}
+
+func stringList(v interface{}) []string {
+	var out []string
+	l, _ := v.([]interface{})
+	for _, x := range l {
+		out = append(out, attrString(x))
+	}
+	return out
+}
+
+func attrValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	return attrString(v)
+}
+
+// listDiff lines up the items that are in both lists, an item that
+// only one of the nodes has leaves a gap on the other side.  The left
+// node's order is kept since run list order matters.
+func listDiff(l, r []string) []diffRow {
+	in := func(s string, list []string) bool {
+		for _, x := range list {
+			if x == s {
+				return true
+			}
+		}
+		return false
+	}
+	all := append([]string{}, l...)
+	for _, s := range r {
+		if !in(s, l) {
+			all = append(all, s)
+		}
+	}
+	var rows []diffRow
+	for _, s := range all {
+		row := diffRow{same: in(s, l) && in(s, r)}
+		if in(s, l) {
+			row.left = "  " + s
+		}
+		if in(s, r) {
+			row.right = "  " + s
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func valueRow(k, l, r string) diffRow {
+	return diffRow{
+		left:  fmt.Sprintf("  %s: %s", k, l),
+		right: fmt.Sprintf("  %s: %s", k, r),
+		same:  l == r,
+	}
+}
+
+// mapDiff only shows the keys whose values differ.
+func mapDiff(l, r map[string]string) []diffRow {
+	var keys []string
+	for k := range l {
+		keys = append(keys, k)
+	}
+	for k := range r {
+		keys = append(keys, k)
+	}
+	var rows []diffRow
+	for _, k := range uniq(keys) {
+		lv, lok := l[k]
+		rv, rok := r[k]
+		if lok && rok && lv == rv {
+			continue
+		}
+		if !lok {
+			lv = "-"
+		}
+		if !rok {
+			rv = "-"
+		}
+		rows = append(rows, valueRow(k, lv, rv))
+	}
+	if len(rows) == 0 {
+		rows = append(rows, diffRow{left: "  no differences", right: "  no differences", same: true})
+	}
+	return rows
+}
+
+func packages(attrs map[string]interface{}) map[string]string {
+	out := map[string]string{}
+	pkgs, _ := attrs["packages"].(map[string]interface{})
+	for name, v := range pkgs {
+		p, _ := v.(map[string]interface{})
+		out[name] = attrValue(p["version"])
+	}
+	return out
+}
+
+// flatten turns nested attributes into dotted paths.
+func flatten(prefix string, v interface{}, out map[string]string) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if prefix != "" {
+			out[prefix] = attrValue(v)
+		}
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := k
+		if prefix != "" {
+			p = prefix + "." + k
+		}
+		flatten(p, m[k], out)
+	}
+}
+
+// showCompare diffs the two selected hosts side by side.
+func showCompare(g *ui.Gui, v *ui.View) error {
+	var targets []node
+	for _, n := range visibleNodes {
+		if n.selected {
+			targets = append(targets, n)
+		}
+	}
+	if len(targets) != 2 {
+		msg <- "select two hosts to compare them"
+		return nil
+	}
+
+	x, y := g.Size()
+	lv, err := g.SetView("compare-left", 0, 0, x/2-1, y-1)
+	if err != nil && err != ui.ErrUnknownView {
+		return err
+	}
+	rv, err := g.SetView("compare-right", x/2, 0, x-1, y-1)
+	if err != nil && err != ui.ErrUnknownView {
+		return err
+	}
+	lv.Title = targets[0].node.Name
+	rv.Title = targets[1].node.Name + " (n/p: scroll, q: close)"
+	lv.Clear()
+	rv.Clear()
+	for _, row := range compareNodes(targets[0], targets[1]) {
+		f := colors["color1"]
+		if row.header {
+			f = colors["color2"]
+		} else if !row.same {
+			f = colors["color3"]
+		}
+		f(lv, row.left)
+		f(rv, row.right)
+	}
+	current = "compare-left"
+	return g.SetCurrentView("compare-left")
+}
+
+func compareScroll(d int) ui.KeybindingHandler {
+	return func(g *ui.Gui, v *ui.View) error {
+		_, oy := v.Origin()
+		oy += d
+		if max := strings.Count(v.Buffer(), "\n") - 1; oy > max {
+			oy = max
+		}
+		if oy < 0 {
+			oy = 0
+		}
+		for _, name := range []string{"compare-left", "compare-right"} {
+			cv, err := g.View(name)
+			if err != nil {
+				return err
+			}
+			if err := cv.SetOrigin(0, oy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func exitCompare(g *ui.Gui, v *ui.View) error {
+	current = "hosts-cursor"
+	g.DeleteView("compare-right")
+	return g.DeleteView("compare-left")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// inventoryCache keeps the names seen in chef searches so completion
+// doesn't have to talk to the chef server.
+type inventoryCache struct {
+	Hosts        []string `json:"hosts"`
+	Roles        []string `json:"roles"`
+	Environments []string `json:"environments"`
+}
+
+func cachePath() string {
+	return filepath.Join(configDir(), "cache.json")
+}
+
+func loadCache() inventoryCache {
+	var c inventoryCache
+	f, err := os.Open(cachePath())
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+	json.NewDecoder(f).Decode(&c)
+	return c
+}
+
+func updateCache(nodes []node) error {
+	c := loadCache()
+	for _, n := range nodes {
+		c.Hosts = append(c.Hosts, n.node.Name)
+		c.Roles = append(c.Roles, n.node.Info.Roles...)
+		if n.node.Environment != "" {
+			c.Environments = append(c.Environments, n.node.Environment)
+		}
+	}
+	c.Hosts = uniq(c.Hosts)
+	c.Roles = uniq(c.Roles)
+	c.Environments = uniq(c.Environments)
+
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(cachePath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(c)
+}
+
+func uniq(in []string) []string {
+	sort.Strings(in)
+	var out []string
+	for i, s := range in {
+		if i == 0 || s != in[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// complete prints the candidates the completion scripts ask for.
+func complete(w io.Writer, kind string) {
+	var out []string
+	switch kind {
+	case "hosts":
+		out = loadCache().Hosts
+	case "roles":
+		out = loadCache().Roles
+	case "environments":
+		out = loadCache().Environments
+	case "knife":
+		c := loadCache()
+		for _, r := range c.Roles {
+			out = append(out, "role:"+r)
+		}
+		for _, e := range c.Environments {
+			out = append(out, "chef_environment:"+e)
+		}
+	case "aliases":
+		out = aliasNames()
+	case "profiles":
+		for k := range cfg.Profiles {
+			out = append(out, k)
+		}
+		sort.Strings(out)
+	case "sets":
+		out = saved.setNames()
+	case "addrs":
+		out = addrStrategies
+	case "commands":
+		for _, c := range kingpin.CommandLine.Model().Commands {
+			if !c.Hidden {
+				out = append(out, c.Name)
+			}
+		}
+	case "flags":
+		for _, f := range allFlags() {
+			out = append(out, "--"+f.Name)
+		}
+	}
+	for _, s := range out {
+		fmt.Fprintln(w, s)
+	}
+}
+
+func allFlags() []*kingpin.FlagModel {
+	m := kingpin.CommandLine.Model()
+	var out []*kingpin.FlagModel
+	for _, f := range m.Flags {
+		if !f.Hidden {
+			out = append(out, f)
+		}
+	}
+	for _, c := range m.Commands {
+		for _, f := range c.Flags {
+			if !f.Hidden {
+				out = append(out, f)
+			}
+		}
+	}
+	return out
+}
+
+func completionScript(w io.Writer, shell string) {
+	switch shell {
+	case "bash":
+		fmt.Fprint(w, bashCompletion)
+	case "zsh":
+		fmt.Fprint(w, zshCompletion)
+	case "fish":
+		fmt.Fprint(w, fishCompletion)
+		for _, f := range allFlags() {
+			line := fmt.Sprintf("complete -c ussh -l %s", f.Name)
+			if f.Short != 0 {
+				line += fmt.Sprintf(" -s %c", f.Short)
+			}
+			if kind, ok := flagCompletions[f.Name]; ok {
+				line += fmt.Sprintf(" -x -a '(ussh complete %s)'", kind)
+			} else if !f.IsBoolFlag() {
+				line += " -r"
+			}
+			fmt.Fprintf(w, "%s -d '%s'\n", line, strings.Replace(f.Help, "'", `\'`, -1))
+		}
+	}
+}
+
+const bashCompletion = `_ussh() {
+    local cur prev words
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        -r|--role) words=$(ussh complete roles) ;;
+        -k|--knife) words=$(ussh complete knife) ;;
+        -a|--addr) words=$(ussh complete addrs) ;;
+        --profile) words=$(ussh complete profiles) ;;
+        --set) words=$(ussh complete sets) ;;
+        *)
+            case "$cur" in
+                @*) words=$(ussh complete aliases) ;;
+                -*) words=$(ussh complete flags) ;;
+                *)
+                    words=$(ussh complete hosts)
+                    if [ "$COMP_CWORD" -eq 1 ]; then
+                        words="$(ussh complete commands) $words"
+                    fi
+                    ;;
+            esac
+            ;;
+    esac
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    if type __ltrim_colon_completions >/dev/null 2>&1; then
+        __ltrim_colon_completions "$cur"
+    fi
+}
+complete -F _ussh ussh
+`
+
+const zshCompletion = `#compdef ussh
+
+_ussh() {
+    local -a cands
+    case "${words[CURRENT-1]}" in
+        -r|--role) cands=(${(f)"$(ussh complete roles)"}) ;;
+        -k|--knife) cands=(${(f)"$(ussh complete knife)"}) ;;
+        -a|--addr) cands=(${(f)"$(ussh complete addrs)"}) ;;
+        --profile) cands=(${(f)"$(ussh complete profiles)"}) ;;
+        --set) cands=(${(f)"$(ussh complete sets)"}) ;;
+        *)
+            case "$PREFIX" in
+                @*) cands=(${(f)"$(ussh complete aliases)"}) ;;
+                -*) cands=(${(f)"$(ussh complete flags)"}) ;;
+                *)
+                    cands=(${(f)"$(ussh complete hosts)"})
+                    if (( CURRENT == 2 )); then
+                        cands+=(${(f)"$(ussh complete commands)"})
+                    fi
+                    ;;
+            esac
+            ;;
+    esac
+    compadd -- $cands
+}
+
+compdef _ussh ussh
+`
+
+const fishCompletion = `complete -c ussh -f
+complete -c ussh -n '__fish_use_subcommand' -a '(ussh complete commands)'
+complete -c ussh -a '(ussh complete hosts)'
+complete -c ussh -a '(ussh complete aliases)'
+`
+
+// flagCompletions are the flags whose values can be completed, and
+// what to complete them with.
+var flagCompletions = map[string]string{
+	"role":    "roles",
+	"knife":   "knife",
+	"addr":    "addrs",
+	"profile": "profiles",
+	"set":     "sets",
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var cfg config
+
+// config holds the default profile plus any named profiles, the
+// fields set in the selected named profile override the defaults.
+type config struct {
+	profile
+	Profiles map[string]profile `json:"profiles"`
+	Aliases  map[string]alias   `json:"aliases"`
+}
+
+type profile struct {
+	Knife            string              `json:"knife"`
+	Address          string              `json:"address"`
+	Jumps            []jump              `json:"jumps"`
+	SSHOptions       []string            `json:"ssh_options"`
+	Tunnels          map[string][]string `json:"tunnels"`
+	FSThreshold      int                 `json:"fs_threshold"`
+	StaleAfter       string              `json:"stale_after"`
+	SkipUnreachable  bool                `json:"skip_unreachable"`
+	HostKeys         string              `json:"host_keys"`
+	Organization     string              `json:"organization"`
+	SignVersion      string              `json:"sign_version"`
+	ServerAPIVersion string              `json:"server_api_version"`
+	CABundle         string              `json:"ca_bundle"`
+	ChefTimeout      string              `json:"chef_timeout"`
+
+	staleAfter  time.Duration
+	chefTimeout time.Duration
+}
+
+// use applies the named profile (if there is one) on top of the
+// defaults.
+func (c *config) use(name string) error {
+	if name != "" {
+		p, ok := c.Profiles[name]
+		if !ok {
+			return fmt.Errorf("no profile named %s in %s", name, configPath())
+		}
+		c.override(p)
+	}
+
+	switch c.HostKeys {
+	case "", "warn", "add", "strict", "off":
+	default:
+		return fmt.Errorf("invalid host_keys %s, it should be warn, add, strict or off", c.HostKeys)
+	}
+
+	switch c.SignVersion {
+	case "", "1.0", "1.3":
+	default:
+		return fmt.Errorf("invalid sign_version %s, it should be 1.0 or 1.3", c.SignVersion)
+	}
+
+	c.staleAfter = defaultStaleAfter
+	if c.StaleAfter != "" {
+		d, err := time.ParseDuration(c.StaleAfter)
+		if err != nil {
+			return fmt.Errorf("invalid stale_after %s: %s", c.StaleAfter, err)
+		}
+		c.staleAfter = d
+	}
+
+	c.chefTimeout = defaultChefTimeout
+	if c.ChefTimeout != "" {
+		d, err := time.ParseDuration(c.ChefTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid chef_timeout %s: %s", c.ChefTimeout, err)
+		}
+		c.chefTimeout = d
+	}
+
+	for i, j := range c.Jumps {
+		if j.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(j.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid jump pattern %s: %s", j.Pattern, err)
+		}
+		c.Jumps[i].re = re
+	}
+	return nil
+}
+
+func (c *config) override(p profile) {
+	if p.Knife != "" {
+		c.Knife = p.Knife
+	}
+	if p.Address != "" {
+		c.Address = p.Address
+	}
+	if len(p.Jumps) > 0 {
+		c.Jumps = p.Jumps
+	}
+	if len(p.SSHOptions) > 0 {
+		c.SSHOptions = p.SSHOptions
+	}
+	if len(p.Tunnels) > 0 {
+		c.Tunnels = p.Tunnels
+	}
+	if p.FSThreshold > 0 {
+		c.FSThreshold = p.FSThreshold
+	}
+	if p.StaleAfter != "" {
+		c.StaleAfter = p.StaleAfter
+	}
+	if p.SkipUnreachable {
+		c.SkipUnreachable = true
+	}
+	if p.HostKeys != "" {
+		c.HostKeys = p.HostKeys
+	}
+	if p.Organization != "" {
+		c.Organization = p.Organization
+	}
+	if p.SignVersion != "" {
+		c.SignVersion = p.SignVersion
+	}
+	if p.ServerAPIVersion != "" {
+		c.ServerAPIVersion = p.ServerAPIVersion
+	}
+	if p.CABundle != "" {
+		c.CABundle = p.CABundle
+	}
+	if p.ChefTimeout != "" {
+		c.ChefTimeout = p.ChefTimeout
+	}
+}
+
+// jump routes nodes through a chain of bastions.  A node matches
+// when every criteria that is set matches, and the first matching jump
+// in the config wins.
+type jump struct {
+	Environment string   `json:"environment"`
+	Role        string   `json:"role"`
+	Pattern     string   `json:"pattern"`
+	Via         []string `json:"via"`
+
+	re *regexp.Regexp
+}
+
+func configDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".ussh")
+}
+
+func configPath() string {
+	if p := os.Getenv("USSH_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(configDir(), "config.json")
+}
+
+func loadConfig() error {
+	f, err := os.Open(configPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return fmt.Errorf("couldn't parse %s: %s", configPath(), err)
+	}
+	return nil
+}
+
+func (j jump) matches(n node) bool {
+	if j.Environment != "" && j.Environment != n.node.Environment {
+		return false
+	}
+	if j.Role != "" && !hasRole(n, j.Role) {
+		return false
+	}
+	if j.re != nil && !j.re.MatchString(n.node.Name) {
+		return false
+	}
+	return true
+}
+
+func hasRole(n node, role string) bool {
+	for _, r := range n.node.Info.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func jumpHosts(n node) string {
+	for _, j := range cfg.Jumps {
+		if j.matches(n) {
+			return strings.Join(j.Via, ",")
+		}
+	}
+	return ""
+}
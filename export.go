@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var groupChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// inventory is the export commands' view of the nodes: host vars
+// come from --vars and groups from the nodes' roles and environments.
+type inventory struct {
+	hosts  []string
+	vars   map[string][][2]string
+	groups map[string][]string
+	nodes  map[string]node
+}
+
+func newInventory(nodes []node, vars []string) inventory {
+	inv := inventory{
+		vars:   map[string][][2]string{},
+		groups: map[string][]string{},
+		nodes:  map[string]node{},
+	}
+	for _, n := range nodes {
+		name := n.node.Name
+		inv.hosts = append(inv.hosts, name)
+		inv.nodes[name] = n
+
+		if a := address(n); a != name {
+			inv.vars[name] = append(inv.vars[name], [2]string{"ansible_host", a})
+		}
+		attrs := attributes(n)
+		for _, v := range vars {
+			if val, ok := lookup(attrs, v); ok {
+				inv.vars[name] = append(inv.vars[name], [2]string{groupName(v), attrString(val)})
+			}
+		}
+
+		for _, r := range n.node.Info.Roles {
+			g := "role_" + groupName(r)
+			inv.groups[g] = append(inv.groups[g], name)
+		}
+		if n.node.Environment != "" {
+			g := "env_" + groupName(n.node.Environment)
+			inv.groups[g] = append(inv.groups[g], name)
+		}
+	}
+	return inv
+}
+
+func (inv inventory) groupNames() []string {
+	var out []string
+	for g := range inv.groups {
+		out = append(out, g)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func groupName(s string) string {
+	return groupChars.ReplaceAllString(s, "_")
+}
+
+func attrString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func export(w io.Writer, nodes []node, format string, vars []string) error {
+	inv := newInventory(nodes, vars)
+	switch format {
+	case "ansible-ini":
+		inv.ini(w)
+	case "ansible-yaml":
+		inv.yaml(w)
+	case "pdsh":
+		for _, h := range inv.hosts {
+			fmt.Fprintln(w, address(inv.nodes[h]))
+		}
+	case "clush":
+		for _, g := range inv.groupNames() {
+			fmt.Fprintf(w, "%s: %s\n", g, strings.Join(inv.groups[g], ","))
+		}
+	case "ssh-config":
+		inv.sshConfig(w)
+	default:
+		return fmt.Errorf("unknown export format %s", format)
+	}
+	return nil
+}
+
+func (inv inventory) ini(w io.Writer) {
+	fmt.Fprintln(w, "[all]")
+	for _, h := range inv.hosts {
+		line := []string{h}
+		for _, kv := range inv.vars[h] {
+			v := kv[1]
+			if strings.ContainsAny(v, " \t\"") {
+				v = fmt.Sprintf("%q", v)
+			}
+			line = append(line, fmt.Sprintf("%s=%s", kv[0], v))
+		}
+		fmt.Fprintln(w, strings.Join(line, " "))
+	}
+	for _, g := range inv.groupNames() {
+		fmt.Fprintf(w, "\n[%s]\n", g)
+		for _, h := range inv.groups[g] {
+			fmt.Fprintln(w, h)
+		}
+	}
+}
+
+// yaml leans on json for the values, json scalars are valid yaml.
+func (inv inventory) yaml(w io.Writer) {
+	fmt.Fprintln(w, "all:\n  hosts:")
+	for _, h := range inv.hosts {
+		fmt.Fprintf(w, "    %s:\n", h)
+		for _, kv := range inv.vars[h] {
+			b, _ := json.Marshal(kv[1])
+			fmt.Fprintf(w, "      %s: %s\n", kv[0], b)
+		}
+	}
+	if len(inv.groups) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "  children:")
+	for _, g := range inv.groupNames() {
+		fmt.Fprintf(w, "    %s:\n      hosts:\n", g)
+		for _, h := range inv.groups[g] {
+			fmt.Fprintf(w, "        %s:\n", h)
+		}
+	}
+}
+
+func (inv inventory) sshConfig(w io.Writer) {
+	for _, h := range inv.hosts {
+		n := inv.nodes[h]
+		fmt.Fprintf(w, "Host %s\n", h)
+		fmt.Fprintf(w, "    HostName %s\n", address(n))
+		fmt.Fprintf(w, "    User %s\n", username)
+		if via := jumpHosts(n); via != "" {
+			fmt.Fprintf(w, "    ProxyJump %s\n", via)
+		}
+		for _, o := range sshConfigOptions() {
+			fmt.Fprintf(w, "    %s\n", o)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// sshConfigOptions translates the ssh options that have an
+// ssh_config equivalent.
+func sshConfigOptions() []string {
+	var out []string
+	for _, o := range sshOpts {
+		switch o[0] {
+		case "-A":
+			out = append(out, "ForwardAgent yes")
+		case "-p":
+			out = append(out, "Port "+o[1])
+		case "-i":
+			out = append(out, "IdentityFile "+o[1])
+		case "-J":
+			out = append(out, "ProxyJump "+o[1])
+		case "-o":
+			out = append(out, strings.Replace(o[1], "=", " ", 1))
+		}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+const defaultStaleAfter = 24 * time.Hour
+
+// checkinTime is when chef-client last ran on the node, ohai_time is
+// seconds since the epoch.  It's called for every node a search
+// returns, so only that attribute is decoded.
+func checkinTime(n node) time.Time {
+	var doc struct {
+		Automatic struct {
+			OhaiTime float64 `json:"ohai_time"`
+		} `json:"automatic"`
+	}
+	if err := json.Unmarshal(n.raw, &doc); err != nil || doc.Automatic.OhaiTime == 0 {
+		return time.Time{}
+	}
+	t := doc.Automatic.OhaiTime
+	sec := int64(t)
+	return time.Unix(sec, int64((t-float64(sec))*1e9))
+}
+
+// stale nodes haven't checked in within stale_after.  Nodes that have
+// never checked in aren't counted, there's nothing to go on.
+func stale(n node) bool {
+	return !n.checkin.IsZero() && time.Since(n.checkin) > cfg.staleAfter
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+func toggleStale(g *ui.Gui, v *ui.View) error {
+	*staleOnly = !*staleOnly
+	fv, _ := g.View("filter")
+	search(fv.Buffer())
+	v.SetCursor(0, 0)
+	printNodes()
+	if *staleOnly {
+		msg <- fmt.Sprintf("showing hosts that haven't checked in for %s", cfg.staleAfter)
+	} else {
+		msg <- "showing all hosts"
+	}
+	return nil
+}
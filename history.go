@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+const maxHistory = 2000
+
+var (
+	history    []historyEntry
+	usedHosts  = map[string]bool{}
+	recentOnly bool
+)
+
+type historyEntry struct {
+	Host    string    `json:"host"`
+	Time    time.Time `json:"time"`
+	Mode    string    `json:"mode"`
+	Profile string    `json:"profile,omitempty"`
+}
+
+func historyPath() string {
+	return filepath.Join(configDir(), "history")
+}
+
+// loadHistory reads the history file, one json entry per line, oldest
+// first.
+func loadHistory() error {
+	f, err := os.Open(historyPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	history = history[:0]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		history = append(history, e)
+		usedHosts[e.Host] = true
+	}
+	return scanner.Err()
+}
+
+func recordHistory(targets []node, mode string) error {
+	now := time.Now()
+	for _, n := range targets {
+		history = append(history, historyEntry{Host: n.node.Name, Time: now, Mode: mode, Profile: *profileName})
+	}
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(historyPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, e := range history {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lastHost() (historyEntry, error) {
+	if len(history) == 0 {
+		return historyEntry{}, fmt.Errorf("you haven't connected to anything yet")
+	}
+	return history[len(history)-1], nil
+}
+
+// frecency scores each host by how often and how recently it was
+// used, older connections count for less.
+func frecency() map[string]float64 {
+	scores := map[string]float64{}
+	now := time.Now()
+	for _, e := range history {
+		age := now.Sub(e.Time)
+		var w float64
+		switch {
+		case age < 4*24*time.Hour:
+			w = 100
+		case age < 14*24*time.Hour:
+			w = 70
+		case age < 31*24*time.Hour:
+			w = 50
+		case age < 90*24*time.Hour:
+			w = 30
+		default:
+			w = 10
+		}
+		scores[e.Host] += w
+	}
+	return scores
+}
+
+// sortHosts puts starred hosts first followed by the most frecently
+// used hosts, the rest stay in alphabetical order.
+func sortHosts() {
+	scores := frecency()
+	sort.Sort(byHost(hosts))
+	sort.SliceStable(hosts, func(i, j int) bool {
+		si, sj := saved.starred(hosts[i].node.Name), saved.starred(hosts[j].node.Name)
+		if si != sj {
+			return si
+		}
+		return scores[hosts[i].node.Name] > scores[hosts[j].node.Name]
+	})
+	for i := range hosts {
+		hosts[i].index = i
+	}
+}
+
+func toggleRecent(g *ui.Gui, v *ui.View) error {
+	recentOnly = !recentOnly
+	fv, _ := g.View("filter")
+	search(fv.Buffer())
+	v.SetCursor(0, 0)
+	printNodes()
+	if recentOnly {
+		msg <- "showing hosts you have connected to before"
+	} else {
+		msg <- "showing all hosts"
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostKey is one of the ssh host keys ohai found on a node.
+type hostKey struct {
+	typ string
+	key string
+}
+
+func (k hostKey) String() string {
+	return k.typ + " " + k.key
+}
+
+// chefHostKeys reads the node's keys.ssh attributes, e.g.
+// host_rsa_public and host_ed25519_public.
+func chefHostKeys(n node) []hostKey {
+	ssh := n.node.Info.Keys["ssh"]
+	var out []hostKey
+	for _, k := range []struct{ attr, typ string }{
+		{"host_ed25519_public", "ssh-ed25519"},
+		{"host_ecdsa_public", ssh["host_ecdsa_type"]},
+		{"host_rsa_public", "ssh-rsa"},
+		{"host_dsa_public", "ssh-dss"},
+	} {
+		if key := ssh[k.attr]; key != "" {
+			if k.typ == "" {
+				k.typ = "ecdsa-sha2-nistp256"
+			}
+			out = append(out, hostKey{typ: k.typ, key: key})
+		}
+	}
+	return out
+}
+
+// knownHostName is how ssh looks the host up in known_hosts.
+func knownHostName(n node) string {
+	if port := sshPort(); port != "22" {
+		return fmt.Sprintf("[%s]:%s", address(n), port)
+	}
+	return address(n)
+}
+
+func knownHostsPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// knownHosts is what's in a known_hosts file, one entry per line.
+type knownHosts []knownHost
+
+type knownHost struct {
+	patterns string
+	key      hostKey
+}
+
+func loadKnownHosts(pth string) (knownHosts, error) {
+	f, err := os.Open(pth)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out knownHosts
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+			continue
+		}
+		out = append(out, knownHost{patterns: fields[0], key: hostKey{typ: fields[1], key: fields[2]}})
+	}
+	return out, scanner.Err()
+}
+
+// lookup returns the keys known_hosts has for the host.
+func (kh knownHosts) lookup(host string) []hostKey {
+	var out []hostKey
+	for _, e := range kh {
+		if e.matches(host) {
+			out = append(out, e.key)
+		}
+	}
+	return out
+}
+
+// matches handles plain, comma separated and hashed (|1|salt|hash)
+// host names.  Wildcard patterns are left to ssh.
+func (e knownHost) matches(host string) bool {
+	if strings.HasPrefix(e.patterns, "|1|") {
+		parts := strings.Split(e.patterns, "|")
+		if len(parts) != 4 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil)) == parts[3]
+	}
+	for _, p := range strings.Split(e.patterns, ",") {
+		if p == host {
+			return true
+		}
+	}
+	return false
+}
+
+// keyMismatches compares chef's keys for a node with known_hosts.  Only
+// keys of the same type can be compared, a host that known_hosts
+// doesn't have a key of that type for isn't a mismatch.
+func keyMismatches(kh knownHosts, n node) (mismatched []hostKey, missing bool) {
+	known := kh.lookup(knownHostName(n))
+	for _, ck := range chefHostKeys(n) {
+		var sameType, same bool
+		for _, k := range known {
+			if k.typ == ck.typ {
+				sameType = true
+				same = same || k.key == ck.key
+			}
+		}
+		if sameType && !same {
+			mismatched = append(mismatched, ck)
+		}
+	}
+	return mismatched, len(known) == 0
+}
+
+func warnHostKey(w io.Writer, n node, k hostKey) {
+	fmt.Fprintln(w, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	fmt.Fprintf(w, "WARNING: the %s host key for %s in %s\n", k.typ, knownHostName(n), knownHostsPath())
+	fmt.Fprintln(w, "doesn't match the one chef has for it.  The host may have been")
+	fmt.Fprintln(w, "rebuilt, or someone may be doing something nasty.")
+	fmt.Fprintf(w, "chef has: %s\n", k)
+	fmt.Fprintln(w, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+}
+
+// verifyHostKeys checks the targets' known_hosts entries against chef
+// before connecting.  Depending on host_keys in the config a mismatch
+// is a warning or stops ussh, and with "add" hosts that known_hosts
+// doesn't have yet are added to it.
+func verifyHostKeys(targets []node) error {
+	mode := cfg.HostKeys
+	if mode == "" {
+		mode = "warn"
+	}
+	if mode == "off" {
+		return nil
+	}
+
+	kh, err := loadKnownHosts(knownHostsPath())
+	if err != nil {
+		return err
+	}
+
+	var bad int
+	var add []string
+	for _, n := range targets {
+		mismatched, missing := keyMismatches(kh, n)
+		for _, k := range mismatched {
+			warnHostKey(os.Stderr, n, k)
+			bad++
+		}
+		if missing && mode == "add" {
+			add = append(add, knownHostsLines(n)...)
+		}
+	}
+	if bad > 0 && mode == "strict" {
+		return fmt.Errorf("not connecting, %d host key(s) don't match chef", bad)
+	}
+	if len(add) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(knownHostsPath()), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(knownHostsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, l := range add {
+		if _, err := fmt.Fprintln(f, l); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "added %d host key(s) from chef to %s\n", len(add), knownHostsPath())
+	return nil
+}
+
+// knownHostsLines are the known_hosts entries for a node's chef keys,
+// the node's ip is included so CheckHostIP doesn't complain.
+func knownHostsLines(n node) []string {
+	names := []string{knownHostName(n)}
+	if ip := n.node.Info.IPAddress; ip != "" && ip != address(n) && net.ParseIP(ip) != nil {
+		if port := sshPort(); port != "22" {
+			ip = fmt.Sprintf("[%s]:%s", ip, port)
+		}
+		names = append(names, ip)
+	}
+	var out []string
+	for _, k := range chefHostKeys(n) {
+		out = append(out, fmt.Sprintf("%s %s", strings.Join(names, ","), k))
+	}
+	return out
+}
+
+// writeKnownHosts writes the nodes' keys to pth, via a temporary file
+// so pth is only replaced once everything has been written.  It won't
+// overwrite ~/.ssh/known_hosts, that has keys ussh knows nothing about.
+func writeKnownHosts(pth string, nodes []node) error {
+	kh, err := loadKnownHosts(knownHostsPath())
+	if err != nil {
+		return err
+	}
+	if pth == "-" {
+		return syncKnownHosts(os.Stdout, kh, nodes)
+	}
+
+	if fi, err := os.Stat(pth); err == nil {
+		if ki, err := os.Stat(knownHostsPath()); err == nil && os.SameFile(fi, ki) {
+			return fmt.Errorf("not overwriting %s, write to another file and add it to ssh's UserKnownHostsFile", pth)
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(pth), filepath.Base(pth)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := syncKnownHosts(tmp, kh, nodes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), pth)
+}
+
+// syncKnownHosts writes a known_hosts file with chef's keys for the
+// nodes, and warns about any that don't match kh (what's in
+// ~/.ssh/known_hosts).
+func syncKnownHosts(w io.Writer, kh knownHosts, nodes []node) error {
+	var none []string
+	for _, n := range nodes {
+		lines := knownHostsLines(n)
+		if len(lines) == 0 {
+			none = append(none, n.node.Name)
+			continue
+		}
+		for _, l := range lines {
+			if _, err := fmt.Fprintln(w, l); err != nil {
+				return err
+			}
+		}
+		mismatched, _ := keyMismatches(kh, n)
+		for _, k := range mismatched {
+			warnHostKey(os.Stderr, n, k)
+		}
+	}
+	if len(none) > 0 {
+		fmt.Fprintf(os.Stderr, "chef has no host keys for %s\n", strings.Join(none, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+var (
+	tree     *attrTree
+	infoSel  int
+	infoFind string
+)
+
+// attrTree is the attribute browser in the info pane.  It holds the
+// whole node json and remembers which branches are open.
+type attrTree struct {
+	host string
+	root *attrNode
+}
+
+type attrNode struct {
+	key      string
+	value    interface{}
+	branch   bool
+	open     bool
+	depth    int
+	parent   *attrNode
+	children []*attrNode
+}
+
+func newAttrTree(n node) *attrTree {
+	doc := nodeJSON(n)
+	root := &attrNode{branch: true, open: true, depth: -1}
+	for _, k := range []string{"automatic", "default", "normal", "override"} {
+		a := buildAttrs(k, doc[k], root)
+		a.open = true
+		root.children = append(root.children, a)
+	}
+	return &attrTree{host: n.node.Name, root: root}
+}
+
+func buildAttrs(key string, val interface{}, parent *attrNode) *attrNode {
+	a := &attrNode{key: key, parent: parent, depth: parent.depth + 1}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		a.branch = true
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			a.children = append(a.children, buildAttrs(k, v[k], a))
+		}
+	case []interface{}:
+		a.branch = true
+		for i, x := range v {
+			a.children = append(a.children, buildAttrs(fmt.Sprintf("[%d]", i), x, a))
+		}
+	default:
+		a.value = val
+	}
+	return a
+}
+
+func (a *attrNode) String() string {
+	indent := strings.Repeat("  ", a.depth)
+	switch {
+	case a.branch && a.open:
+		return fmt.Sprintf("%s- %s", indent, a.key)
+	case a.branch:
+		return fmt.Sprintf("%s+ %s (%d)", indent, a.key, len(a.children))
+	}
+	return fmt.Sprintf("%s  %s: %s", indent, a.key, attrString(a.value))
+}
+
+func (a *attrNode) matches(s string) bool {
+	s = strings.ToLower(s)
+	if strings.Contains(strings.ToLower(a.key), s) {
+		return true
+	}
+	return !a.branch && strings.Contains(strings.ToLower(attrString(a.value)), s)
+}
+
+// walk calls f for a and its descendants, only descending into open
+// branches if all is false.
+func (a *attrNode) walk(all bool, f func(*attrNode)) {
+	for _, c := range a.children {
+		f(c)
+		if c.branch && (all || c.open) {
+			c.walk(all, f)
+		}
+	}
+}
+
+// lines are the attributes that are currently visible.
+func (t *attrTree) lines() []*attrNode {
+	var out []*attrNode
+	t.root.walk(false, func(a *attrNode) { out = append(out, a) })
+	return out
+}
+
+func (t *attrTree) selected() *attrNode {
+	lines := t.lines()
+	if infoSel >= len(lines) {
+		infoSel = len(lines) - 1
+	}
+	if infoSel < 0 {
+		infoSel = 0
+		return nil
+	}
+	return lines[infoSel]
+}
+
+func (t *attrTree) sel(a *attrNode) {
+	for p := a.parent; p != nil; p = p.parent {
+		p.open = true
+	}
+	for i, x := range t.lines() {
+		if x == a {
+			infoSel = i
+		}
+	}
+}
+
+// find moves to the next attribute after the selected one whose key
+// or value contains s, opening its branch if it's hidden.
+func (t *attrTree) find(s string) bool {
+	var all []*attrNode
+	t.root.walk(true, func(a *attrNode) { all = append(all, a) })
+	cur := t.selected()
+	start := 0
+	for i, a := range all {
+		if a == cur {
+			start = i
+		}
+	}
+	for i := 1; i <= len(all); i++ {
+		a := all[(start+i)%len(all)]
+		if a.matches(s) {
+			t.sel(a)
+			return true
+		}
+	}
+	return false
+}
+
+func showInfo(g *ui.Gui, v *ui.View) error {
+	v, _ = g.View("info")
+	if info {
+		v.Clear()
+		info = false
+	} else {
+		info = true
+		printInfo(v)
+	}
+	return nil
+}
+
+func printInfo(v *ui.View) {
+	if !info {
+		return
+	}
+	v.Clear()
+	cv, _ := g.View("hosts-cursor")
+	_, cur := cv.Cursor()
+	if cur < 0 || cur >= len(visibleNodes) {
+		return
+	}
+	n := visibleNodes[cur]
+	if tree == nil || tree.host != n.node.Name {
+		tree = newAttrTree(n)
+		infoSel = 0
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, n)
+	fmt.Fprintln(&buf, "\nAttributes ('I' to browse):")
+	top := bytes.Count(buf.Bytes(), []byte("\n"))
+	v.Write(buf.Bytes())
+	for _, a := range tree.lines() {
+		fmt.Fprintln(v, a)
+	}
+
+	if current != "info" {
+		v.SetOrigin(0, 0)
+		return
+	}
+	tree.selected()
+	_, h := v.Size()
+	_, oy := v.Origin()
+	y := top + infoSel
+	if y < oy+top && infoSel == 0 {
+		oy = 0
+	} else if y < oy {
+		oy = y
+	} else if y >= oy+h {
+		oy = y - h + 1
+	}
+	v.SetOrigin(0, oy)
+	v.SetCursor(0, y-oy)
+}
+
+func printSummary(w io.Writer, n node) {
+	fmt.Fprintf(w, "Name: %s\n", n.node.Name)
+	fmt.Fprintf(w, "Roles: %v\n", n.node.Info.Roles)
+	fmt.Fprintf(w, "Environment: %s\n", n.node.Environment)
+	fmt.Fprintf(w, "IP: %s\n", n.node.Info.IPAddress)
+	fmt.Fprintf(w, "MAC: %s\n", n.node.Info.MACAddress)
+	fmt.Fprintf(w, "Uptime: %s\n", n.node.Info.Uptime)
+	if stale(n) {
+		colors["color4"](w, fmt.Sprintf("Last check-in: %s (stale)", age(n.checkin)))
+	} else {
+		fmt.Fprintf(w, "Last check-in: %s\n", age(n.checkin))
+	}
+	attrs := attributes(n)
+	printMemory(w, attrs)
+	printFilesystem(w, attrs)
+	printCPU(w, attrs)
+}
+
+// browseInfo moves into the info pane, there has to be a host under
+// the cursor to browse.
+func browseInfo(g *ui.Gui, v *ui.View) error {
+	iv, err := g.View("info")
+	if err != nil {
+		return err
+	}
+	cv, _ := g.View("hosts-cursor")
+	if _, cur := cv.Cursor(); cur < 0 || cur >= len(visibleNodes) {
+		msg <- "there's no host to show"
+		return nil
+	}
+	info = true
+	iv.Highlight = true
+	current = "info"
+	printInfo(iv)
+	return nil
+}
+
+func exitInfo(g *ui.Gui, v *ui.View) error {
+	current = "hosts-cursor"
+	v.Highlight = false
+	printInfo(v)
+	return nil
+}
+
+func infoMove(d int) ui.KeybindingHandler {
+	return func(g *ui.Gui, v *ui.View) error {
+		infoSel += d
+		if infoSel < 0 {
+			infoSel = 0
+		}
+		printInfo(v)
+		return nil
+	}
+}
+
+func infoPage(d int) ui.KeybindingHandler {
+	return func(g *ui.Gui, v *ui.View) error {
+		_, h := v.Size()
+		return infoMove(d*(h-1))(g, v)
+	}
+}
+
+func toggleAttr(g *ui.Gui, v *ui.View) error {
+	if tree == nil {
+		return nil
+	}
+	if a := tree.selected(); a != nil && a.branch {
+		a.open = !a.open
+	}
+	printInfo(v)
+	return nil
+}
+
+func openAttr(g *ui.Gui, v *ui.View) error {
+	if tree == nil {
+		return nil
+	}
+	if a := tree.selected(); a != nil && a.branch {
+		a.open = true
+	}
+	printInfo(v)
+	return nil
+}
+
+// closeAttr closes the selected branch, or the branch the selected
+// attribute is in.
+func closeAttr(g *ui.Gui, v *ui.View) error {
+	if tree == nil {
+		return nil
+	}
+	a := tree.selected()
+	if a == nil {
+		return nil
+	}
+	if (!a.branch || !a.open) && a.parent != tree.root {
+		a = a.parent
+	}
+	a.open = false
+	tree.sel(a)
+	printInfo(v)
+	return nil
+}
+
+func searchInfo(g *ui.Gui, v *ui.View) error {
+	return prompt(g, "search attributes", infoFind, func(s string) error {
+		infoFind = s
+		return findNext(g, v)
+	})
+}
+
+func findNext(g *ui.Gui, v *ui.View) error {
+	if infoFind == "" || tree == nil {
+		return nil
+	}
+	if !tree.find(infoFind) {
+		go func() { msg <- fmt.Sprintf("no attributes match %s", infoFind) }()
+	}
+	printInfo(v)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// knifeFile is the knife.rb to use, the first of the profile's knife,
+// .chef/knife.rb, ~/.chef/knife.rb and /etc/chef/client.rb that exists.
+// That's the order knife and chef-golang look in.
+func knifeFile() string {
+	var files []string
+	if cfg.Knife != "" {
+		files = append(files, cfg.Knife)
+	}
+	files = append(files, filepath.Join(".chef", "knife.rb"), filepath.Join(os.Getenv("HOME"), ".chef", "knife.rb"), "/etc/chef/client.rb")
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return ""
+}
+
+// knifeSettings reads the simple `name value` lines of a knife.rb.
+// Anything that needs ruby to work out is left out.
+func knifeSettings(pth string) map[string]string {
+	out := map[string]string{}
+	f, err := os.Open(pth)
+	if err != nil {
+		return out
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		v := fields[1]
+		if len(v) > 1 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			// ruby fills in #{...} in double quotes
+			if v[0] == '"' && strings.Contains(v, "#{") {
+				continue
+			}
+			v = v[1 : len(v)-1]
+		} else if !strings.HasPrefix(v, ":") {
+			continue
+		}
+		out[fields[0]] = v
+	}
+	return out
+}
+
+// tlsConfig verifies the chef server's certificate against the system
+// roots plus the certs in knife's trusted_certs_dir (what `knife ssl
+// fetch` writes to) and the profile's ca_bundle.  Verification is
+// only skipped with --insecure or ssl_verify_mode :verify_none.
+func tlsConfig(knifeRB string, knife map[string]string) (*tls.Config, error) {
+	if *insecure || knife["ssl_verify_mode"] == ":verify_none" {
+		why := "--insecure"
+		if !*insecure {
+			why = "ssl_verify_mode :verify_none in " + knifeRB
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: not verifying the chef server's certificate (%s)\n", why)
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	dir := expandHome(knife["trusted_certs_dir"])
+	if dir == "" && knifeRB != "" {
+		dir = filepath.Join(filepath.Dir(knifeRB), "trusted_certs")
+	}
+	if dir != "" {
+		certs, _ := filepath.Glob(filepath.Join(dir, "*.crt"))
+		pems, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+		for _, c := range append(certs, pems...) {
+			if err := addCerts(pool, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.CABundle != "" {
+		if err := addCerts(pool, expandHome(cfg.CABundle)); err != nil {
+			return nil, err
+		}
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+func addCerts(pool *x509.CertPool, pth string) error {
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("no certificates found in %s", pth)
+	}
+	return nil
+}
+
+// exportProxies puts knife's proxy settings in the environment, where
+// the chef api client looks for them.  Like chef, it leaves variables
+// that are already set alone.
+func exportProxies(knifeRB string, knife map[string]string) error {
+	for _, scheme := range []string{"http", "https"} {
+		p := knife[scheme+"_proxy"]
+		if p == "" || hasEnv(scheme+"_proxy") {
+			continue
+		}
+		if !strings.Contains(p, "://") {
+			p = "http://" + p
+		}
+		u, err := url.Parse(p)
+		if err != nil {
+			return fmt.Errorf("invalid %s_proxy %s in %s: %s", scheme, p, knifeRB, err)
+		}
+		if user := knife[scheme+"_proxy_user"]; user != "" {
+			u.User = url.UserPassword(user, knife[scheme+"_proxy_pass"])
+		}
+		os.Setenv(strings.ToUpper(scheme)+"_PROXY", u.String())
+	}
+	if np := knife["no_proxy"]; np != "" && !hasEnv("no_proxy") {
+		os.Setenv("NO_PROXY", np)
+	}
+	return nil
+}
+
+// hasEnv is true if the upper or lower case form of name is set.
+func hasEnv(name string) bool {
+	return os.Getenv(strings.ToUpper(name)) != "" || os.Getenv(strings.ToLower(name)) != ""
+}
+
+func expandHome(pth string) string {
+	if strings.HasPrefix(pth, "~/") {
+		return filepath.Join(os.Getenv("HOME"), pth[2:])
+	}
+	return pth
+}
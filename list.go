@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	chef "github.com/marpaia/chef-golang"
+)
+
+// host is what ls prints for each node, for a template the whole
+// chef node is available too, e.g. {{.Node.Info.Platform}}.
+type host struct {
+	Name        string     `json:"name"`
+	Address     string     `json:"address"`
+	Environment string     `json:"environment"`
+	Roles       []string   `json:"roles"`
+	IP          string     `json:"ipaddress"`
+	FQDN        string     `json:"fqdn"`
+	Platform    string     `json:"platform"`
+	LastCheckin *time.Time `json:"last_checkin,omitempty"`
+	Stale       bool       `json:"stale"`
+	Node        chef.Node  `json:"-"`
+}
+
+func newHost(n node) host {
+	h := host{
+		Name:        n.node.Name,
+		Address:     address(n),
+		Environment: n.node.Environment,
+		Roles:       n.node.Info.Roles,
+		IP:          n.node.Info.IPAddress,
+		FQDN:        n.node.Info.FQDN,
+		Platform:    n.node.Info.Platform,
+		Stale:       stale(n),
+		Node:        n.node,
+	}
+	if !n.checkin.IsZero() {
+		h.LastCheckin = &n.checkin
+	}
+	return h
+}
+
+func list(w io.Writer, nodes []node, format string) error {
+	hs := make([]host, len(nodes))
+	for i, n := range nodes {
+		hs[i] = newHost(n)
+	}
+
+	switch format {
+	case "plain":
+		for _, h := range hs {
+			fmt.Fprintln(w, h.Name)
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(hs)
+	default:
+		if !strings.HasSuffix(format, "\n") {
+			format += "\n"
+		}
+		t, err := template.New("ls").Parse(format)
+		if err != nil {
+			return fmt.Errorf("invalid format: %s", err)
+		}
+		for _, h := range hs {
+			if err := t.Execute(w, h); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printTargets(w io.Writer, targets []node) {
+	for _, n := range targets {
+		if *withUser {
+			fmt.Fprintf(w, "%s@%s\n", username, address(n))
+		} else {
+			fmt.Fprintln(w, address(n))
+		}
+	}
+}
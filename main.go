@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -26,12 +25,49 @@ const (
 
 var (
 	g            *ui.Gui
-	query        = kingpin.Arg("query", "turns the arg into a knife search of 'hostname:<ARG>'").String()
+	sshCmd       = kingpin.Command("ssh", "pick nodes and log into them (the default)").Default()
+	query        = sshCmd.Arg("query", "turns the arg into a knife search of 'hostname:<ARG>'").String()
+	lsCmd        = kingpin.Command("ls", "print the nodes that match the query")
+	lsFormat     = lsCmd.Flag("format", "plain, json, or a go template such as '{{.Name}} {{.IP}}'").Default("plain").String()
+	pickCmd      = kingpin.Command("pick", "pick nodes and print them instead of logging in (same as --print)")
+	exportCmd    = kingpin.Command("export", "write the nodes that match the query as an inventory for other tools")
+	exportFormat = exportCmd.Flag("format", "ansible-ini, ansible-yaml, pdsh, clush or ssh-config").Default("ansible-ini").Enum("ansible-ini", "ansible-yaml", "pdsh", "clush", "ssh-config")
+	exportVars   = exportCmd.Flag("vars", "comma separated node attributes to add as host vars, e.g. platform,kernel.release").String()
+	knownCmd     = kingpin.Command("known-hosts", "work with the ssh host keys chef has for the nodes")
+	syncCmd      = knownCmd.Command("sync", "write a known_hosts file with chef's host keys for the nodes that match the query")
+	syncOut      = syncCmd.Flag("output", "file to write the keys to, - for stdout").Short('o').Default("-").String()
+	attrCmd      = kingpin.Command("attr", "print a node's attribute, e.g. ussh attr web1 kernel.release")
+	attrHost     = attrCmd.Arg("node", "the node's name").Required().String()
+	attrPath     = attrCmd.Arg("path", "the attribute, e.g. network.interfaces.eth0.addresses, tags[0] or filesystem.*.mount").Default("$").String()
+	bagCmd       = kingpin.Command("bag", "print a data bag item, e.g. ussh bag users alice")
+	bagName      = bagCmd.Arg("bag", "the data bag").Required().String()
+	bagItem      = bagCmd.Arg("item", "the item in the data bag").Required().String()
+	bagPath      = bagCmd.Arg("path", "part of the item to print, in the same form as attr's path").Default("$").String()
+	asJSON       = kingpin.Flag("json", "print attr and bag values as json, even if they're a single value").Bool()
+	aliasesCmd   = kingpin.Command("aliases", "list the query aliases in the config file")
+	completeCmd  = kingpin.Command("completion", "print a shell completion script, e.g. source <(ussh completion bash)")
+	shell        = completeCmd.Arg("shell", "bash, zsh or fish").Required().Enum("bash", "zsh", "fish")
+	candidateCmd = kingpin.Command("complete", "print completion candidates").Hidden()
+	candidates   = candidateCmd.Arg("kind", "hosts, roles, environments, knife, aliases, profiles, sets, addrs, commands or flags").Required().String()
+	printSel     = kingpin.Flag("print", "print the selected hosts (using --addr) instead of logging in").Bool()
+	withUser     = kingpin.Flag("with-user", "prefix printed hosts with USSH_USER@").Bool()
+	setName      = kingpin.Flag("set", "connect to a saved set of hosts without opening the menu").String()
+	last         = kingpin.Flag("last", "reconnect to the last host you connected to (same as 'ussh -')").Bool()
 	knife        = kingpin.Flag("knife", "uses the passed in value as a raw knife search").Short('k').String()
 	filterStr    = kingpin.Flag("filter", "filter string").Short('f').String()
 	fake         = kingpin.Flag("mock", "fake nodes").Short('m').Bool()
 	role         = kingpin.Flag("role", "chef role").Short('r').String()
-	scp          = kingpin.Flag("scp", "file to scp to targets").Short('s').String()
+	scp          = kingpin.Flag("scp", "local file or directory to copy to targets").Short('s').String()
+	pull         = kingpin.Flag("pull", "remote file or directory to copy from each target into <dest>/<host>").String()
+	dest         = kingpin.Flag("dest", "remote destination for --scp (default is the home dir) or local destination for --pull (default is .)").Short('d').String()
+	useRsync     = kingpin.Flag("rsync", "use rsync instead of scp for --scp and --pull").Bool()
+	parallel     = kingpin.Flag("parallel", "number of hosts to copy to/from at the same time").Default("5").Int()
+	profileName  = kingpin.Flag("profile", "config profile to use").OverrideDefaultFromEnvar("USSH_PROFILE").String()
+	addr         = kingpin.Flag("addr", "address to connect to: name, fqdn, ip, ec2 or iface:<name>").Short('a').String()
+	probeHosts   = kingpin.Flag("probe", "check that the visible hosts' ssh port can be reached when the menu opens").Bool()
+	skipDown     = kingpin.Flag("skip-unreachable", "leave hosts that can't be reached out of multi host logins and transfers").Bool()
+	insecure     = kingpin.Flag("insecure", "don't verify the chef server's certificate").Bool()
+	staleOnly    = kingpin.Flag("stale", "only show nodes that haven't checked in with chef for stale_after (24h by default)").Bool()
 	username     string
 	info         bool
 	current      string
@@ -48,8 +84,10 @@ var (
 
 type node struct {
 	node     chef.Node
+	raw      json.RawMessage
 	selected bool
 	index    int
+	checkin  time.Time
 }
 
 type byHost []node
@@ -59,14 +97,14 @@ func (b byHost) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
 func (b byHost) Less(i, j int) bool { return b[i].node.Name < b[j].node.Name }
 
 func init() {
+	lsCmd.Arg("query", "turns the arg into a knife search of 'hostname:<ARG>'").StringVar(query)
+	pickCmd.Arg("query", "turns the arg into a knife search of 'hostname:<ARG>'").StringVar(query)
+	exportCmd.Arg("query", "turns the arg into a knife search of 'hostname:<ARG>'").StringVar(query)
+	syncCmd.Arg("query", "turns the arg into a knife search of 'hostname:<ARG>'").StringVar(query)
 	msg = make(chan string)
 	f, _ = os.Create("/tmp/ussh.log")
 	log.SetOutput(f)
 	username = os.Getenv("USSH_USER")
-	if username == "" {
-		fmt.Println("please set the $USSH_USER env var to your ldap username.")
-		os.Exit(1)
-	}
 
 	window = 20
 	w := os.Getenv("USSH_WINDOW")
@@ -80,23 +118,218 @@ func init() {
 }
 
 func main() {
-	kingpin.Parse()
+	if username == "" {
+		fmt.Fprintln(os.Stderr, "please set the $USSH_USER env var to your ldap username.")
+		os.Exit(1)
+	}
+
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	args, extra := splitArgs(os.Args[1:])
+	args, err := expandAliases(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	cmd := kingpin.MustParse(kingpin.CommandLine.Parse(dashArgs(args)))
+	switch cmd {
+	case aliasesCmd.FullCommand():
+		for _, a := range aliasNames() {
+			fmt.Println(a)
+		}
+		return
+	case completeCmd.FullCommand():
+		completionScript(os.Stdout, *shell)
+		return
+	case candidateCmd.FullCommand():
+		if err := loadSaved(); err == nil {
+			complete(os.Stdout, *candidates)
+		}
+		return
+	}
+
+	if err := loadHistory(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	if err := loadSaved(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	var names []string
+	if *last {
+		prev, err := lastHost()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		if *profileName == "" {
+			*profileName = prev.Profile
+		}
+		names = []string{prev.Host}
+	} else if *setName != "" {
+		var ok bool
+		if names, ok = saved.Sets[*setName]; !ok {
+			fmt.Fprintf(os.Stderr, "there is no saved set named %s\n", *setName)
+			exit(1)
+		}
+	}
+
+	if err := cfg.use(*profileName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	if err := setupSSHArgs(extra); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	for _, a := range []string{cfg.Address, *addr} {
+		if err := setAddrStrategy(a); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+	}
+
+	if cmd == attrCmd.FullCommand() {
+		if err := showAttr(os.Stdout, *attrHost, *attrPath, *asJSON); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		return
+	}
+
+	if cmd == bagCmd.FullCommand() {
+		if err := showBagItem(os.Stdout, *bagName, *bagItem, *bagPath, *asJSON); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		return
+	}
+
+	if len(names) > 0 {
+		targets, err := findNodes(names)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		finish(cmd, targets)
+		return
+	}
+
 	if *fake {
 		getFakeNodes()
 	} else {
 		getNodes()
 	}
 
+	if cmd == lsCmd.FullCommand() {
+		if err := list(os.Stdout, filterNodes(*filterStr, -1), *lsFormat); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		return
+	}
+
+	if cmd == exportCmd.FullCommand() {
+		var vars []string
+		if *exportVars != "" {
+			vars = strings.Split(*exportVars, ",")
+		}
+		if err := export(os.Stdout, filterNodes(*filterStr, -1), *exportFormat, vars); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		return
+	}
+
+	if cmd == syncCmd.FullCommand() {
+		if err := writeKnownHosts(*syncOut, filterNodes(*filterStr, -1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		return
+	}
+
 	if *filterStr != "" {
 		search(*filterStr)
 	}
 
 	targets := getTargets()
 	f.Close()
+	if launchSet != "" {
+		var err error
+		if targets, err = findNodes(saved.Sets[launchSet]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+	}
+	finish(cmd, targets)
+	stopTunnels()
+}
+
+// dashArgs turns a - into --last unless it's a flag's value, as in
+// known-hosts sync -o -.  Those are joined to their flag since kingpin
+// won't take a bare - as a value.
+func dashArgs(args []string) []string {
+	takesValue := map[string]bool{}
+	var walk func([]*kingpin.FlagModel, []*kingpin.CmdModel)
+	walk = func(flags []*kingpin.FlagModel, cmds []*kingpin.CmdModel) {
+		for _, f := range flags {
+			if f.IsBoolFlag() {
+				continue
+			}
+			takesValue["--"+f.Name] = true
+			if f.Short != 0 {
+				takesValue["-"+string(f.Short)] = true
+			}
+		}
+		for _, c := range cmds {
+			walk(c.Flags, c.Commands)
+		}
+	}
+	m := kingpin.CommandLine.Model()
+	walk(m.Flags, m.Commands)
+
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "-":
+			a = "--last"
+		case takesValue[a] && i+1 < len(args) && args[i+1] == "-":
+			if strings.HasPrefix(a, "--") {
+				a += "=-"
+			} else {
+				a += "-"
+			}
+			i++
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// exit stops any tunnels that were started from the menu before
+// exiting, the ssh processes behind them would outlive ussh otherwise.
+func exit(code int) {
+	stopTunnels()
+	os.Exit(code)
+}
+
+// finish prints or logs into the targets.
+func finish(cmd string, targets []node) {
+	if *printSel || cmd == pickCmd.FullCommand() {
+		printTargets(os.Stdout, targets)
+		return
+	}
 	login(targets)
 }
 
-func getTargets() []string {
+func getTargets() []node {
 	g = ui.NewGui()
 	if err := g.Init(); err != nil {
 		log.Fatal("could not init", err)
@@ -117,40 +350,52 @@ func getTargets() []string {
 	g.SetLayout(layout)
 	g.Cursor = true
 
+	if *probeHosts {
+		probeVisible(g, nil)
+	}
+
 	if err := g.MainLoop(); err != nil {
 		if err != ui.ErrQuit {
-			log.Fatal(err)
+			g.Close()
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
 		}
 	}
-	var out []string
+	var out []node
 	for _, n := range visibleNodes {
 		if n.selected {
-			out = append(out, n.node.Name)
+			out = append(out, n)
 		}
 	}
 	return out
 }
 
 func search(pred string) {
-	my := len(hosts)
-	if g != nil {
-		_, my = g.Size()
-		my -= 5
-	}
+	visibleNodes = filterNodes(pred, window)
+}
+
+// filterNodes returns up to max of the hosts whose names contain all
+// of the comma separated terms in pred.  A max < 0 means no limit.
+func filterNodes(pred string, max int) []node {
 	pred = strings.TrimSpace(pred)
 	preds := strings.Split(pred, ",")
 
-	var i int
-	visibleNodes = []node{}
+	out := []node{}
 	for _, n := range hosts {
-		if i < window && inAll(n.node.Name, preds) {
-			visibleNodes = append(visibleNodes, n)
-			i++
-		}
-		if i >= window {
+		if max >= 0 && len(out) >= max {
 			break
 		}
+		if recentOnly && !usedHosts[n.node.Name] {
+			continue
+		}
+		if *staleOnly && !stale(n) {
+			continue
+		}
+		if inAll(n.node.Name, preds) {
+			out = append(out, n)
+		}
 	}
+	return out
 }
 
 func inAll(h string, preds []string) bool {
@@ -190,6 +435,20 @@ func showHelp(g *ui.Gui, v *ui.View) error {
 		f(v, "	   p: Move cursor to the previous host (up arrow does same thing)")
 		f(v, "	   c: Copy the current host to the clipboard")
 		f(v, "	   C: Copy the current host to the clipboard with 'USSH_USER@' prepended to the host")
+		f(v, "	   a: Cycle the address used to connect (name, fqdn, ip, ec2)")
+		f(v, "	   r: Toggle showing only the hosts you have connected to before")
+		f(v, "	   P: Check that the selected (or visible) hosts can be reached, unreachable hosts are marked with !")
+		f(v, "	   s: Toggle showing only the hosts that haven't checked in with chef lately")
+		f(v, "	   *: Star (or unstar) the current host, starred hosts are listed first")
+		f(v, "	   S: Save the selected hosts as a named set")
+		f(v, "	   L: List the saved sets")
+		f(v, "	   t: Open an ssh tunnel (port forward) to the current host")
+		f(v, "	   T: Show the open tunnels")
+		f(v, "	   o: Browse the chef roles, enter shows the nodes in a role")
+		f(v, "	   e: Browse the chef environments, enter shows the nodes in an environment")
+		f(v, "	   R: Show the run list, recipes and cookbook versions of the current host")
+		f(v, "	   d: Compare the two selected hosts side by side")
+		f(v, "	   I: Browse the current host's attributes (/: search, N: next match, enter: open/close, q: back)")
 		f(v, "	   q: Exit the help screen")
 		current = "help"
 		v.Editable = false
@@ -294,6 +553,14 @@ func printNodes() {
 			f = colors["color3"]
 		} else if n.selected || i == cur {
 			f = colors["color2"]
+		} else if stale(n) {
+			f = colors["color4"]
+		}
+		if saved.starred(n.node.Name) {
+			postfix += " *"
+		}
+		if unreachable(n) {
+			postfix += " !"
 		}
 		f(hv, fmt.Sprintf("%s%s%s", prefix, n.node.Name, postfix))
 	}
@@ -313,6 +580,10 @@ func getElipsis(i int) (string, string) {
 }
 
 func edit(v *ui.View, key ui.Key, ch rune, mod ui.Modifier) {
+	if v.Name() == "prompt" {
+		editPrompt(v, key, ch, mod)
+		return
+	}
 	if key == ui.KeyEnter {
 		cv, _ := g.View("hosts-cursor")
 		cv.SetCursor(0, 0)
@@ -370,6 +641,7 @@ var keys = []key{
 	{"hosts-cursor", ui.KeyEnter, ui.ModNone, ssh},
 	{"hosts-cursor", ui.KeyCtrlI, ui.ModNone, showInfo},
 	{"hosts-cursor", 'i', ui.ModNone, showInfo},
+	{"hosts-cursor", 'I', ui.ModNone, browseInfo},
 	{"hosts-cursor", ui.KeyCtrlF, ui.ModNone, filter},
 	{"hosts-cursor", 'f', ui.ModNone, filter},
 	{"hosts-cursor", ui.KeyCtrlH, ui.ModNone, showHelp},
@@ -377,6 +649,65 @@ var keys = []key{
 	{"hosts-cursor", ui.KeyCtrlC, ui.ModNone, copyToClipboard},
 	{"hosts-cursor", 'c', ui.ModNone, copyToClipboard},
 	{"hosts-cursor", 'C', ui.ModNone, copyToClipboardWithUsername},
+	{"hosts-cursor", 'a', ui.ModNone, toggleAddress},
+	{"hosts-cursor", 'r', ui.ModNone, toggleRecent},
+	{"hosts-cursor", 's', ui.ModNone, toggleStale},
+	{"hosts-cursor", 'P', ui.ModNone, probeVisible},
+	{"hosts-cursor", 't', ui.ModNone, openTunnel},
+	{"hosts-cursor", 'T', ui.ModNone, showTunnels},
+	{"hosts-cursor", '*', ui.ModNone, star},
+	{"hosts-cursor", 'S', ui.ModNone, saveSet},
+	{"hosts-cursor", 'L', ui.ModNone, showSets},
+	{"hosts-cursor", 'd', ui.ModNone, showCompare},
+	{"hosts-cursor", 'R', ui.ModNone, showRunList},
+	{"hosts-cursor", 'o', ui.ModNone, browseRoles},
+	{"hosts-cursor", 'e', ui.ModNone, browseEnvironments},
+	{"tunnels", 'n', ui.ModNone, listDown},
+	{"tunnels", ui.KeyArrowDown, ui.ModNone, listDown},
+	{"tunnels", 'p', ui.ModNone, listUp},
+	{"tunnels", ui.KeyArrowUp, ui.ModNone, listUp},
+	{"tunnels", 'x', ui.ModNone, stopTunnel},
+	{"tunnels", 'q', ui.ModNone, exitTunnels},
+	{"sets", 'n', ui.ModNone, listDown},
+	{"sets", ui.KeyArrowDown, ui.ModNone, listDown},
+	{"sets", 'p', ui.ModNone, listUp},
+	{"sets", ui.KeyArrowUp, ui.ModNone, listUp},
+	{"sets", ui.KeyEnter, ui.ModNone, launch},
+	{"sets", 'x', ui.ModNone, deleteSet},
+	{"sets", 'q', ui.ModNone, exitSets},
+	{"info", 'n', ui.ModNone, infoMove(1)},
+	{"info", ui.KeyArrowDown, ui.ModNone, infoMove(1)},
+	{"info", 'p', ui.ModNone, infoMove(-1)},
+	{"info", ui.KeyArrowUp, ui.ModNone, infoMove(-1)},
+	{"info", ui.KeyPgdn, ui.ModNone, infoPage(1)},
+	{"info", ui.KeyPgup, ui.ModNone, infoPage(-1)},
+	{"info", ui.KeyEnter, ui.ModNone, toggleAttr},
+	{"info", ui.KeySpace, ui.ModNone, toggleAttr},
+	{"info", ui.KeyArrowRight, ui.ModNone, openAttr},
+	{"info", ui.KeyArrowLeft, ui.ModNone, closeAttr},
+	{"info", '/', ui.ModNone, searchInfo},
+	{"info", 'N', ui.ModNone, findNext},
+	{"info", 'q', ui.ModNone, exitInfo},
+	{"browse", 'n', ui.ModNone, browseMove(1)},
+	{"browse", ui.KeyArrowDown, ui.ModNone, browseMove(1)},
+	{"browse", 'p', ui.ModNone, browseMove(-1)},
+	{"browse", ui.KeyArrowUp, ui.ModNone, browseMove(-1)},
+	{"browse", ui.KeyEnter, ui.ModNone, drill},
+	{"browse", 'q', ui.ModNone, exitBrowse},
+	{"runlist", 'n', ui.ModNone, listDown},
+	{"runlist", ui.KeyArrowDown, ui.ModNone, listDown},
+	{"runlist", 'p', ui.ModNone, listUp},
+	{"runlist", ui.KeyArrowUp, ui.ModNone, listUp},
+	{"runlist", 'q', ui.ModNone, exitRunList},
+	{"compare-left", 'n', ui.ModNone, compareScroll(1)},
+	{"compare-left", ui.KeyArrowDown, ui.ModNone, compareScroll(1)},
+	{"compare-left", 'p', ui.ModNone, compareScroll(-1)},
+	{"compare-left", ui.KeyArrowUp, ui.ModNone, compareScroll(-1)},
+	{"compare-left", ui.KeyPgdn, ui.ModNone, compareScroll(20)},
+	{"compare-left", ui.KeyPgup, ui.ModNone, compareScroll(-20)},
+	{"compare-left", 'q', ui.ModNone, exitCompare},
+	{"prompt", ui.KeyEnter, ui.ModNone, finishPrompt},
+	{"prompt", ui.KeyCtrlG, ui.ModNone, closePrompt},
 }
 
 func keybindings(g *ui.Gui) error {
@@ -506,14 +837,39 @@ func prev(g *ui.Gui, v *ui.View) error {
 	return err
 }
 
+// listDown and listUp move the cursor in the panels that show one
+// item per line.
+func listDown(g *ui.Gui, v *ui.View) error {
+	_, cy := v.Cursor()
+	lines := strings.Split(strings.TrimSpace(v.Buffer()), "\n")
+	if cy+1 >= len(lines) {
+		return nil
+	}
+	return v.SetCursor(0, cy+1)
+}
+
+func listUp(g *ui.Gui, v *ui.View) error {
+	_, cy := v.Cursor()
+	if cy == 0 {
+		return nil
+	}
+	return v.SetCursor(0, cy-1)
+}
+
 func ssh(g *ui.Gui, v *ui.View) error {
 	_, cy := v.Cursor()
+	if cy < 0 || cy >= len(visibleNodes) {
+		return nil
+	}
 	visibleNodes[cy].selected = true
 	return ui.ErrQuit
 }
 
 func sel(g *ui.Gui, v *ui.View) error {
 	_, cy := v.Cursor()
+	if cy < 0 || cy >= len(visibleNodes) {
+		return nil
+	}
 	if visibleNodes[cy].selected {
 		visibleNodes[cy].selected = false
 	} else {
@@ -523,103 +879,90 @@ func sel(g *ui.Gui, v *ui.View) error {
 	return nil
 }
 
-func showInfo(g *ui.Gui, v *ui.View) error {
-	v, _ = g.View("info")
-	if info {
-		v.Clear()
-		info = false
-	} else {
-		info = true
-		printInfo(v)
-	}
-	return nil
-}
-
-func printInfo(v *ui.View) {
-	if info {
-		v.Clear()
-		cv, _ := g.View("hosts-cursor")
-		_, cur := cv.Cursor()
-		n := visibleNodes[cur]
-		fmt.Fprintf(v, "Name: %s\n", n.node.Name)
-		fmt.Fprintf(v, "Roles: %v\n", n.node.Info.Roles)
-		fmt.Fprintf(v, "Environment: %s\n", n.node.Environment)
-		fmt.Fprintf(v, "IP: %s\n", n.node.Info.IPAddress)
-		fmt.Fprintf(v, "MAC: %s\n", n.node.Info.MACAddress)
-		fmt.Fprintf(v, "Uptime: %s\n", n.node.Info.Uptime)
-		printMemory(v, n.node)
-		printFilesystem(v, n.node)
-		printCPU(v, n.node)
+func login(targets []node) {
+	if len(targets) == 0 {
+		return
 	}
-}
-
-func printMemory(v *ui.View, n chef.Node) {
-	fmt.Fprint(v, "Memory:\n")
-	fmt.Fprintf(v, "  active: %s\n", n.Info.Memory["active"])
-	fmt.Fprintf(v, "  free: %s\n", n.Info.Memory["free"])
-	fmt.Fprintf(v, "  inactive: %s\n", n.Info.Memory["inactive"])
-}
 
-func printFilesystem(v *ui.View, n chef.Node) {
-	fmt.Fprint(v, "Filesystem:\n")
-	for k, val := range n.Info.Filesystem {
-		if strings.Index(k, "/dev") > -1 {
-			fmt.Fprintf(v, "  %s\n", k)
-			fmt.Fprintf(v, "    Size: %v\n", val.KBSize)
-			fmt.Fprintf(v, "    Used: %v\n", val.KBUsed)
-			fmt.Fprintf(v, "    Available: %v\n", val.KBavailable)
-			fmt.Fprintf(v, "    PercentUsed: %v\n", val.PercentUsed)
+	if (*skipDown || cfg.SkipUnreachable) && len(targets) > 1 {
+		if targets = reachable(targets); len(targets) == 0 {
+			fmt.Fprintln(os.Stderr, "none of the hosts can be reached")
+			exit(1)
 		}
 	}
-}
 
-func printCPU(v *ui.View, n chef.Node) {
-	fmt.Fprint(v, "CPU: \n")
-	for k, val := range n.Info.CPU {
-		if k == "cores" {
-			fmt.Fprintf(v, "  %s\n", k)
-			fmt.Fprintf(v, "    Cores: %v\n", val)
-		}
+	if err := verifyHostKeys(targets); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
 
-		if k == "cores" {
-			fmt.Fprintf(v, "    Cores: %v\n", val)
-		}
+	if err := recordHistory(targets, loginMode(targets)); err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't save history:", err)
 	}
-}
 
-func login(targets []string) {
-	if len(targets) == 1 && targets[0] != "" && len(*scp) == 0 {
-		cmd := exec.Command("ssh", fmt.Sprintf("%s@%s", username, targets[0]))
+	if len(*scp) > 0 || len(*pull) > 0 {
+		transfer(targets)
+	} else if len(targets) == 1 {
+		args := append(sshArgs(targets[0]), fmt.Sprintf("%s@%s", username, address(targets[0])))
+		args = append(args, remoteCmd...)
+		cmd := exec.Command("ssh", args...)
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			log.Fatal("couldn't ssh", err)
-		}
-	} else if len(targets) >= 1 && targets[0] != "" && len(*scp) > 0 {
-		for _, x := range targets {
-			cmd := exec.Command("scp", *scp, fmt.Sprintf("%s@%s:", username, x))
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			err := cmd.Run()
-			if err != nil {
-				log.Fatal("couldn't scp", err)
+		if err := cmd.Run(); err != nil {
+			// ssh exits with the remote command's status, pass it on
+			if e, ok := err.(*exec.ExitError); ok {
+				exit(e.ExitCode())
 			}
+			fmt.Fprintln(os.Stderr, "couldn't ssh:", err)
+			exit(1)
 		}
-	} else if len(targets) > 1 {
-		for i, x := range targets {
-			targets[i] = fmt.Sprintf("%s@%s", username, x)
-		}
-		cmd := exec.Command("csshx", targets...)
-		err := cmd.Run()
+	} else {
+		args, err := csshxArgs(targets)
 		if err != nil {
-			log.Fatal(err)
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		cmd := exec.Command("csshx", args...)
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
 		}
 	}
 }
 
+func loginMode(targets []node) string {
+	switch {
+	case len(*scp) > 0:
+		return "scp"
+	case len(*pull) > 0:
+		return "pull"
+	case len(targets) == 1:
+		return "ssh"
+	}
+	return "csshx"
+}
+
+// csshxArgs only has one set of ssh args for all of its
+// hosts so every target has to agree on them.
+func csshxArgs(targets []node) ([]string, error) {
+	var args []string
+	opts := shellJoin(sshArgs(targets[0]))
+	if opts != "" {
+		args = append(args, "--ssh_args", opts)
+	}
+	if len(remoteCmd) > 0 {
+		args = append(args, "--remote_command", strings.Join(remoteCmd, " "))
+	}
+	for _, x := range targets {
+		if shellJoin(sshArgs(x)) != opts {
+			return nil, fmt.Errorf("%s and %s need different ssh options (jump hosts), connect to them separately", targets[0].node.Name, x.node.Name)
+		}
+		args = append(args, fmt.Sprintf("%s@%s", username, address(x)))
+	}
+	return args, nil
+}
+
 func getFakeNodes() {
 	f := []string{"com", "net"}
 	e := []string{"prod", "staging"}
@@ -627,20 +970,39 @@ func getFakeNodes() {
 	for i := 0; i < 30; i++ {
 		n := node{node: chef.Node{Name: fmt.Sprintf("server%d.%s", i, f[i%2]), Environment: e[i%2]}, index: i}
 		hosts[i] = n
-		if i < window {
+	}
+	sortHosts()
+	for _, n := range hosts {
+		if len(visibleNodes) < window {
 			visibleNodes = append(visibleNodes, n)
 		}
 	}
 }
 
-func getNodes() {
+func connect() {
+	if client != nil {
+		return
+	}
 
-	c, err := chef.Connect()
+	// chef-golang and setupChef have to read the same knife.rb
+	knifeRB := knifeFile()
+	if knifeRB == "" {
+		fmt.Fprintln(os.Stderr, "couldn't find a knife.rb, set knife in the config file")
+		exit(1)
+	}
+	c, err := chef.Connect(knifeRB)
+	if err == nil {
+		err = setupChef(c, knifeRB)
+	}
 	if err != nil {
-		log.Fatal("Error:", err)
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
 	}
+	client = c
+}
 
-	c.SSLNoVerify = true
+func getNodes() {
+	connect()
 
 	var q string
 	if *knife != "" {
@@ -652,26 +1014,25 @@ func getNodes() {
 		}
 	}
 
-	resp, err := c.Search("node", q)
+	found, err := searchNodes(q)
 	if err != nil {
-		log.Fatal("search", err)
+		fmt.Fprintln(os.Stderr, "search failed:", err)
+		exit(1)
 	}
 
-	if len(resp.Rows) == 0 {
-		fmt.Printf("No nodes found with query %s, please try again with a different search\n", *query)
-		os.Exit(0)
-	}
-
-	for i, x := range resp.Rows {
-		var cn chef.Node
-		json.Unmarshal(x, &cn)
-		n := node{node: cn, index: i}
-		hosts = append(hosts, n)
+	if len(found) == 0 {
+		shown := *query
+		if *knife != "" {
+			shown = *knife
+		}
+		fmt.Fprintf(os.Stderr, "No nodes found with query %s, please try again with a different search\n", shown)
+		exit(0)
 	}
 
-	sort.Sort(byHost(hosts))
-	for i, _ := range hosts {
-		hosts[i].index = i
+	hosts = found
+	sortHosts()
+	if err := updateCache(hosts); err != nil {
+		log.Println("couldn't update the completion cache", err)
 	}
 	end := window
 	if len(hosts) < window {
@@ -681,6 +1042,23 @@ func getNodes() {
 
 }
 
+func searchNodes(q string) ([]node, error) {
+	resp, err := client.Search("node", q)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []node
+	for i, x := range resp.Rows {
+		var cn chef.Node
+		json.Unmarshal(x, &cn)
+		n := node{node: cn, raw: x, index: i}
+		n.checkin = checkinTime(n)
+		out = append(out, n)
+	}
+	return out, nil
+}
+
 func setupColors() {
 	m := map[string]string{
 		"black":   "30",
@@ -705,6 +1083,10 @@ func setupColors() {
 	if color3 == "" {
 		color3 = "yellow"
 	}
+	color4 := os.Getenv("USSH_COLOR_4")
+	if color4 == "" {
+		color4 = "red"
+	}
 
 	colors = map[string]func(io.Writer, string){
 		"color1": func(w io.Writer, s string) {
@@ -718,6 +1100,10 @@ func setupColors() {
 		"color3": func(w io.Writer, s string) {
 			fmt.Fprintf(w, fmt.Sprintf("\033[%sm%%s\033[%sm\n", m[color3], m[color1]), s)
 		},
+
+		"color4": func(w io.Writer, s string) {
+			fmt.Fprintf(w, fmt.Sprintf("\033[%sm%%s\033[%sm\n", m[color4], m[color1]), s)
+		},
 	}
 
 	hostLabel = fmt.Sprintf("\033[%smhosts\033[%sm\n", m[color2], m[color1])
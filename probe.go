@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+const (
+	probeTimeout  = 2 * time.Second
+	probeParallel = 32
+)
+
+var (
+	probeLock sync.Mutex
+	probed    = map[string]error{}
+)
+
+// sshPort is the port passed to ssh with -p or -o Port, or 22.
+func sshPort() string {
+	port := "22"
+	for _, o := range sshOpts {
+		switch o[0] {
+		case "-p":
+			port = o[1]
+		case "-o":
+			if k, v := sshOption(o[1]); strings.EqualFold(k, "Port") && v != "" {
+				port = v
+			}
+		}
+	}
+	return port
+}
+
+// sshOption splits an -o option into its keyword and value, which ssh
+// lets you separate with spaces or an =.
+func sshOption(o string) (string, string) {
+	o = strings.TrimSpace(o)
+	i := strings.IndexAny(o, " \t=")
+	if i < 0 {
+		return o, ""
+	}
+	v := strings.TrimLeft(o[i:], " \t")
+	v = strings.TrimPrefix(v, "=")
+	return o[:i], strings.TrimSpace(v)
+}
+
+// probe dials each node's ssh port.  Nodes behind a jump host are
+// skipped since they usually can't be reached directly.  done is
+// called after each node.
+func probe(nodes []node, done func()) {
+	sem := make(chan bool, probeParallel)
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		if jumpHosts(n) != "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- true
+		go func(n node) {
+			defer wg.Done()
+			c, err := net.DialTimeout("tcp", net.JoinHostPort(address(n), sshPort()), probeTimeout)
+			if err == nil {
+				c.Close()
+			}
+			probeLock.Lock()
+			probed[n.node.Name] = err
+			probeLock.Unlock()
+			<-sem
+			if done != nil {
+				done()
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func probeErr(n node) error {
+	probeLock.Lock()
+	defer probeLock.Unlock()
+	return probed[n.node.Name]
+}
+
+// unreachable is true if the last probe of the node failed.
+func unreachable(n node) bool {
+	return probeErr(n) != nil
+}
+
+// probeVisible probes the selected hosts, or all of the visible hosts
+// if none are selected, without blocking the ui.
+func probeVisible(g *ui.Gui, v *ui.View) error {
+	var nodes []node
+	for _, n := range visibleNodes {
+		if n.selected {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, visibleNodes...)
+	}
+	go func() {
+		msg <- fmt.Sprintf("probing %d host(s) on port %s", len(nodes), sshPort())
+		probe(nodes, func() {
+			g.Execute(func(g *ui.Gui) error {
+				printNodes()
+				return nil
+			})
+		})
+		var down int
+		for _, n := range nodes {
+			if unreachable(n) {
+				down++
+			}
+		}
+		msg <- fmt.Sprintf("%d of %d host(s) unreachable", down, len(nodes))
+	}()
+	return nil
+}
+
+// reachable drops the hosts that can't be reached from a multi host
+// login or transfer, probing the ones that haven't been probed yet.
+func reachable(targets []node) []node {
+	var todo []node
+	probeLock.Lock()
+	for _, n := range targets {
+		if _, ok := probed[n.node.Name]; !ok {
+			todo = append(todo, n)
+		}
+	}
+	probeLock.Unlock()
+	probe(todo, nil)
+
+	var out []node
+	for _, n := range targets {
+		if err := probeErr(n); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %s\n", n.node.Name, err)
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+var (
+	promptDone   func(string) error
+	promptReturn string
+)
+
+// prompt opens a one line input box at the bottom of the screen.
+// done is called with what was typed once enter is hit, C-g cancels.
+func prompt(g *ui.Gui, label, initial string, done func(string) error) error {
+	x, y := g.Size()
+	v, err := g.SetView("prompt", -1, y-2, x, y)
+	if err != nil && err != ui.ErrUnknownView {
+		return err
+	}
+	v.Frame = false
+	v.Editable = true
+	v.Clear()
+
+	lv, err := g.SetView("prompt-label", -1, y-3, x, y-1)
+	if err != nil && err != ui.ErrUnknownView {
+		return err
+	}
+	lv.Frame = false
+	lv.Clear()
+	colors["color2"](lv, label)
+
+	fmt.Fprint(v, initial)
+	promptDone = done
+	promptReturn = current
+	current = "prompt"
+	if err := g.SetCurrentView("prompt"); err != nil {
+		return err
+	}
+	return v.SetCursor(len(initial), 0)
+}
+
+func editPrompt(v *ui.View, key ui.Key, ch rune, mod ui.Modifier) {
+	s := strings.TrimRight(v.Buffer(), "\n")
+	switch {
+	case (key == ui.KeyBackspace || key == ui.KeyBackspace2) && len(s) > 0:
+		s = s[:len(s)-1]
+	case key == ui.KeySpace:
+		s += " "
+	case ch != 0 && mod == 0 && unicode.IsPrint(ch):
+		s += string(ch)
+	default:
+		return
+	}
+	v.Clear()
+	fmt.Fprint(v, s)
+	v.SetCursor(len(s), 0)
+}
+
+func finishPrompt(g *ui.Gui, v *ui.View) error {
+	s := strings.TrimSpace(v.Buffer())
+	done := promptDone
+	if err := closePrompt(g, v); err != nil {
+		return err
+	}
+	return done(s)
+}
+
+func closePrompt(g *ui.Gui, v *ui.View) error {
+	current = promptReturn
+	promptDone = nil
+	g.DeleteView("prompt-label")
+	return g.DeleteView("prompt")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pathStep is one part of an attribute path such as
+// filesystem["/dev/sda1"].mount or network.interfaces.*.addresses[0].
+type pathStep struct {
+	key   string
+	index int
+	isIdx bool
+	wild  bool
+}
+
+func parsePath(p string) ([]pathStep, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	var steps []pathStep
+	for len(p) > 0 {
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+		case p[0] == '[':
+			end := strings.Index(p, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("missing ] in %s", p)
+			}
+			s := p[1:end]
+			p = p[end+1:]
+			switch {
+			case s == "*":
+				steps = append(steps, pathStep{wild: true})
+			case len(s) > 1 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0]:
+				steps = append(steps, pathStep{key: s[1 : len(s)-1]})
+			default:
+				i, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index [%s], use a number, * or a quoted key", s)
+				}
+				steps = append(steps, pathStep{index: i, isIdx: true})
+			}
+		default:
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			if k := p[:end]; k == "*" {
+				steps = append(steps, pathStep{wild: true})
+			} else {
+				steps = append(steps, pathStep{key: k})
+			}
+			p = p[end:]
+		}
+	}
+	return steps, nil
+}
+
+func wildPath(steps []pathStep) bool {
+	for _, s := range steps {
+		if s.wild {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPath returns everything in v the path matches, in a stable
+// order.  Negative indexes count from the end.
+func selectPath(v interface{}, steps []pathStep) []interface{} {
+	if len(steps) == 0 {
+		return []interface{}{v}
+	}
+	s, rest := steps[0], steps[1:]
+	var out []interface{}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if s.wild {
+			keys := make([]string, 0, len(x))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				out = append(out, selectPath(x[k], rest)...)
+			}
+		} else if val, ok := x[s.key]; ok && !s.isIdx {
+			out = selectPath(val, rest)
+		}
+	case []interface{}:
+		if s.wild {
+			for _, val := range x {
+				out = append(out, selectPath(val, rest)...)
+			}
+		} else if s.isIdx {
+			i := s.index
+			if i < 0 {
+				i += len(x)
+			}
+			if i >= 0 && i < len(x) {
+				out = selectPath(x[i], rest)
+			}
+		}
+	}
+	return out
+}
+
+// printSelection prints scalars as they are and anything else as
+// json.  A path with a wildcard prints each match on its own line, or
+// a json array with asJSON.
+func printSelection(w io.Writer, vals []interface{}, wild, asJSON bool) error {
+	if wild && !asJSON {
+		for _, v := range vals {
+			if err := printValue(w, v, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var v interface{} = vals
+	if !wild {
+		v = vals[0]
+	}
+	return printValue(w, v, asJSON)
+}
+
+func printValue(w io.Writer, v interface{}, asJSON bool) error {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		asJSON = true
+	}
+	if !asJSON {
+		_, err := fmt.Fprintln(w, attrString(v))
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func selectAndPrint(w io.Writer, doc interface{}, pth string, asJSON bool) (bool, error) {
+	steps, err := parsePath(pth)
+	if err != nil {
+		return false, err
+	}
+	vals := selectPath(doc, steps)
+	if len(vals) == 0 {
+		return false, nil
+	}
+	return true, printSelection(w, vals, wildPath(steps), asJSON)
+}
+
+// showAttr prints one of a node's (merged) attributes.
+func showAttr(w io.Writer, name, pth string, asJSON bool) error {
+	nodes, err := findNodes([]string{name})
+	if err != nil {
+		return err
+	}
+	ok, err := selectAndPrint(w, attributes(nodes[0]), pth, asJSON)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s has no attribute %s", name, pth)
+	}
+	return err
+}
+
+// showBagItem prints a data bag item, or part of it.
+func showBagItem(w io.Writer, bag, item, pth string, asJSON bool) error {
+	if *fake {
+		return fmt.Errorf("data bags aren't available with --mock")
+	}
+	connect()
+	var doc interface{}
+	if err := chefGet(fmt.Sprintf("data/%s/%s", bag, item), &doc); err != nil {
+		return fmt.Errorf("couldn't get %s from the %s data bag: %s", item, bag, err)
+	}
+	ok, err := selectAndPrint(w, doc, pth, asJSON)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s/%s has nothing at %s", bag, item, pth)
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want []pathStep
+		err  bool
+	}{
+		{path: "$"},
+		{path: "kernel.release", want: []pathStep{{key: "kernel"}, {key: "release"}}},
+		{path: "$.kernel", want: []pathStep{{key: "kernel"}}},
+		{path: "tags[0]", want: []pathStep{{key: "tags"}, {index: 0, isIdx: true}}},
+		{path: "tags[-1]", want: []pathStep{{key: "tags"}, {index: -1, isIdx: true}}},
+		{path: `filesystem["/dev/sda1"].mount`, want: []pathStep{{key: "filesystem"}, {key: "/dev/sda1"}, {key: "mount"}}},
+		{path: "a['b.c']", want: []pathStep{{key: "a"}, {key: "b.c"}}},
+		{path: "filesystem.*.mount", want: []pathStep{{key: "filesystem"}, {wild: true}, {key: "mount"}}},
+		{path: "network.interfaces[*].addresses[0]", want: []pathStep{{key: "network"}, {key: "interfaces"}, {wild: true}, {key: "addresses"}, {index: 0, isIdx: true}}},
+		{path: "a[x]", err: true},
+		{path: "a[0", err: true},
+	} {
+		got, err := parsePath(tc.path)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: error %v, want error %v", tc.path, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.path, got, tc.want)
+		}
+	}
+}
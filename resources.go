@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultFSThreshold = 90
+
+var sizeUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"tb", 1 << 40},
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"t", 1 << 40},
+	{"g", 1 << 30},
+	{"m", 1 << 20},
+	{"k", 1 << 10},
+	{"b", 1},
+}
+
+// parseSize turns an ohai size such as "16337316kB" into bytes.  Plain
+// numbers are multiplied by unit, ohai's kb_* values have no suffix.
+func parseSize(v interface{}, unit float64) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x * unit, true
+	case string:
+		s := strings.ToLower(strings.TrimSpace(x))
+		for _, u := range sizeUnits {
+			if strings.HasSuffix(s, u.suffix) {
+				s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+				unit = u.mult
+				break
+			}
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, false
+		}
+		return f * unit, true
+	}
+	return 0, false
+}
+
+func humanize(b float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
+
+func usageBar(pct float64) string {
+	const width = 20
+	n := int(pct/100*width + 0.5)
+	if n > width {
+		n = width
+	} else if n < 0 {
+		n = 0
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat("-", width-n) + "]"
+}
+
+func fsThreshold() float64 {
+	if cfg.FSThreshold > 0 {
+		return float64(cfg.FSThreshold)
+	}
+	return defaultFSThreshold
+}
+
+func printMemory(w io.Writer, attrs map[string]interface{}) {
+	fmt.Fprint(w, "Memory:\n")
+	mem, _ := attrs["memory"].(map[string]interface{})
+	total, ok := parseSize(mem["total"], 1024)
+	if !ok || total == 0 {
+		fmt.Fprint(w, "  unknown\n")
+		return
+	}
+	used := total
+	if avail, ok := parseSize(mem["available"], 1024); ok {
+		used -= avail
+	} else {
+		for _, k := range []string{"free", "buffers", "cached"} {
+			if b, ok := parseSize(mem[k], 1024); ok {
+				used -= b
+			}
+		}
+	}
+	pct := used / total * 100
+	fmt.Fprintf(w, "  %s %3.0f%% %s of %s\n", usageBar(pct), pct, humanize(used), humanize(total))
+
+	var parts []string
+	for _, k := range []string{"active", "inactive", "buffers", "cached", "free"} {
+		if b, ok := parseSize(mem[k], 1024); ok {
+			parts = append(parts, fmt.Sprintf("%s: %s", k, humanize(b)))
+		}
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(w, "  %s\n", strings.Join(parts, ", "))
+	}
+
+	swap, _ := mem["swap"].(map[string]interface{})
+	if st, ok := parseSize(swap["total"], 1024); ok && st > 0 {
+		sf, _ := parseSize(swap["free"], 1024)
+		fmt.Fprintf(w, "  swap: %s of %s\n", humanize(st-sf), humanize(st))
+	}
+}
+
+type filesystem struct {
+	device  string
+	mount   string
+	fsType  string
+	size    float64
+	used    float64
+	percent float64
+}
+
+// filesystems reads both the old ohai layout (keyed by device) and the
+// newer one that has by_mountpoint.  Anything without a size (proc,
+// sysfs and friends) is left out.
+func filesystems(attrs map[string]interface{}) []filesystem {
+	fs, _ := attrs["filesystem"].(map[string]interface{})
+	var out []filesystem
+	if bm, ok := fs["by_mountpoint"].(map[string]interface{}); ok {
+		for mount, v := range bm {
+			m, _ := v.(map[string]interface{})
+			var dev string
+			if d, ok := m["devices"].([]interface{}); ok && len(d) > 0 {
+				dev, _ = d[0].(string)
+			}
+			if f, ok := newFilesystem(dev, mount, m); ok {
+				out = append(out, f)
+			}
+		}
+	} else {
+		for dev, v := range fs {
+			m, _ := v.(map[string]interface{})
+			mount, _ := m["mount"].(string)
+			if f, ok := newFilesystem(dev, mount, m); ok {
+				out = append(out, f)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].mount < out[j].mount })
+	return out
+}
+
+func newFilesystem(dev, mount string, m map[string]interface{}) (filesystem, bool) {
+	size, ok := parseSize(m["kb_size"], 1024)
+	if !ok || size == 0 {
+		return filesystem{}, false
+	}
+	f := filesystem{device: dev, mount: mount, size: size}
+	f.fsType, _ = m["fs_type"].(string)
+	f.used, _ = parseSize(m["kb_used"], 1024)
+	f.percent = f.used / size * 100
+	if p, ok := m["percent_used"]; ok {
+		if s, ok := p.(string); ok {
+			p = strings.TrimSuffix(s, "%")
+		}
+		if pct, ok := parseSize(p, 1); ok {
+			f.percent = pct
+		}
+	}
+	return f, true
+}
+
+// printFilesystem shows every mounted filesystem, the ones over the
+// fs_threshold in the config are highlighted.
+func printFilesystem(w io.Writer, attrs map[string]interface{}) {
+	fmt.Fprint(w, "Filesystem:\n")
+	for _, f := range filesystems(attrs) {
+		mount := f.mount
+		if mount == "" {
+			mount = "(not mounted)"
+		}
+		fmt.Fprintf(w, "  %s (%s on %s)\n", mount, f.fsType, f.device)
+		line := fmt.Sprintf("    %s %3.0f%% %s of %s", usageBar(f.percent), f.percent, humanize(f.used), humanize(f.size))
+		if f.percent >= fsThreshold() {
+			colors["color3"](w, line)
+		} else {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func printCPU(w io.Writer, attrs map[string]interface{}) {
+	fmt.Fprint(w, "CPU:\n")
+	cpu, _ := attrs["cpu"].(map[string]interface{})
+	for _, k := range []string{"total", "real", "cores"} {
+		if val, ok := cpu[k]; ok {
+			fmt.Fprintf(w, "  %s: %v\n", k, val)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	ui "github.com/jroimartin/gocui"
+	chef "github.com/marpaia/chef-golang"
+)
+
+var (
+	client *chef.Chef
+
+	cookbookLock sync.Mutex
+	cookbooks    = map[string]envCookbooks{}
+)
+
+// envCookbooks are the cookbook versions in effect in an environment
+// and the version constraints the environment pins.
+type envCookbooks struct {
+	versions map[string]string
+	pins     map[string]string
+	err      error
+}
+
+func getEnvCookbooks(env string) envCookbooks {
+	cookbookLock.Lock()
+	defer cookbookLock.Unlock()
+	if ec, ok := cookbooks[env]; ok {
+		return ec
+	}
+
+	ec := envCookbooks{versions: map[string]string{}, pins: map[string]string{}}
+	cbs, err := client.GetEnvironmentCookbooks(env)
+	if err != nil {
+		ec.err = err
+		return ec
+	}
+	for name, cb := range cbs {
+		if cb != nil && len(cb.Versions) > 0 {
+			ec.versions[name] = cb.Versions[0].Version
+		}
+	}
+	if e, ok, err := client.GetEnvironment(env); err == nil && ok {
+		ec.pins = e.CookbookVersions
+	}
+	cookbooks[env] = ec
+	return ec
+}
+
+// recipeCookbooks are the cookbooks the node's recipes come from.
+func recipeCookbooks(recipes []string) []string {
+	var out []string
+	for _, r := range recipes {
+		out = append(out, strings.SplitN(r, "::", 2)[0])
+	}
+	return uniq(out)
+}
+
+func showRunList(g *ui.Gui, v *ui.View) error {
+	_, cur := v.Cursor()
+	if cur >= len(visibleNodes) {
+		return nil
+	}
+	n := visibleNodes[cur]
+
+	x, y := g.Size()
+	rv, err := g.SetView("runlist", getWidth()+13, 0, x, y-1)
+	if err != nil {
+		if err != ui.ErrUnknownView {
+			return err
+		}
+		rv.Highlight = true
+	}
+	rv.Title = fmt.Sprintf("%s run list (q: close)", n.node.Name)
+	current = "runlist"
+	printRunList(rv, n, nil)
+
+	if client != nil && n.node.Environment != "" {
+		go func() {
+			ec := getEnvCookbooks(n.node.Environment)
+			g.Execute(func(g *ui.Gui) error {
+				if rv, err := g.View("runlist"); err == nil {
+					printRunList(rv, n, &ec)
+				}
+				return nil
+			})
+		}()
+	}
+	return g.SetCurrentView("runlist")
+}
+
+func printRunList(v *ui.View, n node, ec *envCookbooks) {
+	v.Clear()
+	f := colors["color2"]
+	f(v, "run list")
+	for _, r := range n.node.RunList {
+		fmt.Fprintf(v, "  %s\n", r)
+	}
+
+	f(v, "roles")
+	for _, r := range n.node.Info.Roles {
+		fmt.Fprintf(v, "  %s\n", r)
+	}
+
+	recipes := append([]string{}, n.node.Info.Recipes...)
+	sort.Strings(recipes)
+	f(v, "recipes")
+	for _, r := range recipes {
+		fmt.Fprintf(v, "  %s\n", r)
+	}
+
+	f(v, fmt.Sprintf("cookbooks (environment %s)", n.node.Environment))
+	switch {
+	case client == nil:
+		fmt.Fprintln(v, "  not available without a chef server")
+		return
+	case ec == nil:
+		fmt.Fprintln(v, "  loading...")
+		return
+	case ec.err != nil:
+		fmt.Fprintf(v, "  couldn't get the cookbooks: %s\n", ec.err)
+		return
+	}
+	for _, cb := range recipeCookbooks(recipes) {
+		ver, ok := ec.versions[cb]
+		if !ok {
+			ver = "?"
+		}
+		if pin, ok := ec.pins[cb]; ok {
+			fmt.Fprintf(v, "  %s %s (pinned %s)\n", cb, ver, pin)
+		} else {
+			fmt.Fprintf(v, "  %s %s\n", cb, ver)
+		}
+	}
+}
+
+func exitRunList(g *ui.Gui, v *ui.View) error {
+	current = "hosts-cursor"
+	return g.DeleteView("runlist")
+}
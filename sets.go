@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+var (
+	saved     savedHosts
+	launchSet string
+)
+
+// savedHosts are the starred hosts and the named sets of hosts saved
+// from the picker.
+type savedHosts struct {
+	Starred []string            `json:"starred"`
+	Sets    map[string][]string `json:"sets"`
+}
+
+func setsPath() string {
+	return filepath.Join(configDir(), "sets.json")
+}
+
+func loadSaved() error {
+	f, err := os.Open(setsPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&saved); err != nil {
+		return fmt.Errorf("couldn't parse %s: %s", setsPath(), err)
+	}
+	return nil
+}
+
+func (s savedHosts) save() error {
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(setsPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func (s savedHosts) starred(name string) bool {
+	for _, h := range s.Starred {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s savedHosts) setNames() []string {
+	var out []string
+	for k := range s.Sets {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func star(g *ui.Gui, v *ui.View) error {
+	_, cur := v.Cursor()
+	if cur >= len(visibleNodes) {
+		return nil
+	}
+	name := visibleNodes[cur].node.Name
+	if saved.starred(name) {
+		var starred []string
+		for _, h := range saved.Starred {
+			if h != name {
+				starred = append(starred, h)
+			}
+		}
+		saved.Starred = starred
+	} else {
+		saved.Starred = append(saved.Starred, name)
+	}
+	printNodes()
+	return saved.save()
+}
+
+// saveSet saves the selected hosts, or the current host if none are
+// selected, under a name.
+func saveSet(g *ui.Gui, v *ui.View) error {
+	_, cur := v.Cursor()
+	var names []string
+	for _, n := range visibleNodes {
+		if n.selected {
+			names = append(names, n.node.Name)
+		}
+	}
+	if len(names) == 0 && cur < len(visibleNodes) {
+		names = append(names, visibleNodes[cur].node.Name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	return prompt(g, fmt.Sprintf("save %d host(s) as", len(names)), "", func(s string) error {
+		if s == "" {
+			return nil
+		}
+		if saved.Sets == nil {
+			saved.Sets = map[string][]string{}
+		}
+		saved.Sets[s] = names
+		go func() { msg <- fmt.Sprintf("saved %s", s) }()
+		return saved.save()
+	})
+}
+
+func showSets(g *ui.Gui, v *ui.View) error {
+	x, y := g.Size()
+	if v, err := g.SetView("sets", getWidth()+13, 0, x, y-1); err != nil {
+		if err != ui.ErrUnknownView {
+			return err
+		}
+		v.Highlight = true
+		v.Title = "saved sets (enter: connect, x: delete, q: close)"
+	}
+	current = "sets"
+	printSets()
+	return g.SetCurrentView("sets")
+}
+
+func printSets() {
+	v, err := g.View("sets")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	if len(saved.Sets) == 0 {
+		fmt.Fprintln(v, "no saved sets, select some hosts and type 'S' to save one")
+		return
+	}
+	for _, k := range saved.setNames() {
+		fmt.Fprintf(v, "%s: %s\n", k, strings.Join(saved.Sets[k], ", "))
+	}
+}
+
+func currentSet(v *ui.View) string {
+	_, cy := v.Cursor()
+	names := saved.setNames()
+	if cy >= len(names) {
+		return ""
+	}
+	return names[cy]
+}
+
+func launch(g *ui.Gui, v *ui.View) error {
+	launchSet = currentSet(v)
+	if launchSet == "" {
+		return nil
+	}
+	return ui.ErrQuit
+}
+
+func deleteSet(g *ui.Gui, v *ui.View) error {
+	name := currentSet(v)
+	if name == "" {
+		return nil
+	}
+	delete(saved.Sets, name)
+	printSets()
+	v.SetCursor(0, 0)
+	return saved.save()
+}
+
+func exitSets(g *ui.Gui, v *ui.View) error {
+	current = "hosts-cursor"
+	return g.DeleteView("sets")
+}
+
+// findNodes does a fresh search for nodes by name, for when the
+// hosts to connect to didn't come from the picker.
+func findNodes(names []string) ([]node, error) {
+	if *fake {
+		getFakeNodes()
+	} else {
+		q := make([]string, len(names))
+		for i, n := range names {
+			q[i] = fmt.Sprintf("name:%s", n)
+		}
+		connect()
+		found, err := searchNodes(strings.Join(q, " OR "))
+		if err != nil {
+			return nil, err
+		}
+		hosts = found
+	}
+
+	found := map[string]node{}
+	for _, n := range hosts {
+		found[n.node.Name] = n
+	}
+	var out []node
+	for _, name := range names {
+		n, ok := found[name]
+		if !ok {
+			return nil, fmt.Errorf("couldn't find %s", name)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
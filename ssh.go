@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// sshValueFlags are the ssh flags that take a value.
+	sshValueFlags = "BbcDEeFIiJLlmOopQRSWw"
+	// scpFlags are the ssh flags scp understands too (-p becomes -P).
+	scpFlags = "46CqvcFiJop"
+)
+
+var (
+	sshOpts   [][]string
+	remoteCmd []string
+)
+
+// splitArgs splits the command line at the first --, everything after
+// it is passed on to ssh.
+func splitArgs(args []string) ([]string, []string) {
+	for i, a := range args {
+		if a == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// setupSSHArgs combines the profile's ssh_options with the ones passed
+// after -- on the command line.
+func setupSSHArgs(extra []string) error {
+	opts, cmd, err := parseSSHArgs(cfg.SSHOptions)
+	if err != nil {
+		return err
+	}
+	if len(cmd) > 0 {
+		return fmt.Errorf("ssh_options in %s can only contain options, found %s", configPath(), strings.Join(cmd, " "))
+	}
+
+	o, cmd, err := parseSSHArgs(extra)
+	if err != nil {
+		return err
+	}
+	if len(cmd) > 0 && (len(*scp) > 0 || len(*pull) > 0) {
+		return fmt.Errorf("a remote command can't be used with --scp or --pull")
+	}
+
+	sshOpts = append(opts, o...)
+	remoteCmd = cmd
+	return nil
+}
+
+// parseSSHArgs separates ssh options from the remote command that
+// follows them.  Bundled flags are split up, so -AL 8080:db:5432 comes
+// back as [-A] [-L 8080:db:5432].
+func parseSSHArgs(args []string) ([][]string, []string, error) {
+	var opts [][]string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if len(a) < 2 || a[0] != '-' {
+			return opts, args[i:], nil
+		}
+		for j := 1; j < len(a); j++ {
+			flag := "-" + string(a[j])
+			if !strings.ContainsRune(sshValueFlags, rune(a[j])) {
+				opts = append(opts, []string{flag})
+				continue
+			}
+			if j+1 < len(a) {
+				opts = append(opts, []string{flag, a[j+1:]})
+			} else if i+1 < len(args) {
+				i++
+				opts = append(opts, []string{flag, args[i]})
+			} else {
+				return nil, nil, fmt.Errorf("ssh option %s needs a value", flag)
+			}
+			break
+		}
+	}
+	return opts, nil, nil
+}
+
+// sshArgs are the options every ssh and rsync invocation for n needs.
+func sshArgs(n node) []string {
+	var args []string
+	if via := jumpHosts(n); via != "" {
+		args = append(args, "-o", fmt.Sprintf("ProxyJump=%s", via))
+	}
+	for _, o := range sshOpts {
+		args = append(args, o...)
+	}
+	return args
+}
+
+// scpArgs is sshArgs minus the options scp doesn't accept.
+func scpArgs(n node) []string {
+	var args []string
+	if via := jumpHosts(n); via != "" {
+		args = append(args, "-o", fmt.Sprintf("ProxyJump=%s", via))
+	}
+	for _, o := range sshOpts {
+		if !strings.Contains(scpFlags, o[0][1:]) {
+			continue
+		}
+		if o[0] == "-p" {
+			o = []string{"-P", o[1]}
+		}
+		args = append(args, o...)
+	}
+	return args
+}
+
+// shellJoin is for the tools (rsync -e, csshx --ssh_args) that take a
+// whole ssh command line as a single argument.
+func shellJoin(args []string) string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		if strings.ContainsAny(a, " \t'\"") {
+			a = "'" + strings.Replace(a, "'", `'\''`, -1) + "'"
+		}
+		out[i] = a
+	}
+	return strings.Join(out, " ")
+}
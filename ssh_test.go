@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSSHArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		opts [][]string
+		cmd  []string
+		err  bool
+	}{
+		{},
+		{args: []string{"-AL", "8080:db:5432", "uptime"}, opts: [][]string{{"-A"}, {"-L", "8080:db:5432"}}, cmd: []string{"uptime"}},
+		{args: []string{"-p2222", "-i", "key", "ls", "-l"}, opts: [][]string{{"-p", "2222"}, {"-i", "key"}}, cmd: []string{"ls", "-l"}},
+		{args: []string{"-o", "StrictHostKeyChecking=no"}, opts: [][]string{{"-o", "StrictHostKeyChecking=no"}}},
+		{args: []string{"-tt", "sudo", "-i"}, opts: [][]string{{"-t"}, {"-t"}}, cmd: []string{"sudo", "-i"}},
+		{args: []string{"-L"}, err: true},
+	} {
+		opts, cmd, err := parseSSHArgs(tc.args)
+		if (err != nil) != tc.err {
+			t.Errorf("%v: error %v, want error %v", tc.args, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, tc.opts) || !reflect.DeepEqual(cmd, tc.cmd) {
+			t.Errorf("%v: got %v %v, want %v %v", tc.args, opts, cmd, tc.opts, tc.cmd)
+		}
+	}
+}
+
+func TestSCPArgs(t *testing.T) {
+	defer func(o [][]string, j []jump) { sshOpts, cfg.Jumps = o, j }(sshOpts, cfg.Jumps)
+
+	for _, tc := range []struct {
+		opts  [][]string
+		jumps []jump
+		want  []string
+	}{
+		{},
+		{
+			opts: [][]string{{"-p", "2222"}, {"-A"}, {"-i", "k"}, {"-L", "1:h:2"}, {"-v"}, {"-c", "aes"}, {"-t"}, {"-o", "User=x"}},
+			want: []string{"-P", "2222", "-i", "k", "-v", "-c", "aes", "-o", "User=x"},
+		},
+		{
+			opts:  [][]string{{"-4"}, {"-D", "1080"}},
+			jumps: []jump{{Via: []string{"bastion1", "bastion2"}}},
+			want:  []string{"-o", "ProxyJump=bastion1,bastion2", "-4"},
+		},
+	} {
+		sshOpts, cfg.Jumps = tc.opts, tc.jumps
+		if got := scpArgs(node{}); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestSSHPort(t *testing.T) {
+	defer func(o [][]string) { sshOpts = o }(sshOpts)
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{want: "22"},
+		{args: []string{"-p", "2222"}, want: "2222"},
+		{args: []string{"-p2222", "uptime"}, want: "2222"},
+		{args: []string{"-o", "Port=2222"}, want: "2222"},
+		{args: []string{"-oPort=2222"}, want: "2222"},
+		{args: []string{"-o", "port 2222"}, want: "2222"},
+		{args: []string{"-o", "Port = 2222"}, want: "2222"},
+		{args: []string{"-o", "Port"}, want: "22"},
+		{args: []string{"-o", "User=x", "-p", "2200", "-o", "Port=2222"}, want: "2222"},
+	} {
+		opts, _, err := parseSSHArgs(tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sshOpts = opts
+		if got := sshPort(); got != tc.want {
+			t.Errorf("%v: port is %s, want %s", tc.args, got, tc.want)
+		}
+	}
+}
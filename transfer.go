@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// scpProgressEvery is how often a host's scp reports it's still going.
+const scpProgressEvery = 10 * time.Second
+
+var stdoutLock sync.Mutex
+
+type transferResult struct {
+	host string
+	dur  time.Duration
+	err  error
+}
+
+func transfer(targets []node) {
+	if len(*scp) > 0 && len(*pull) > 0 {
+		fmt.Fprintln(os.Stderr, "--scp and --pull can't be used together")
+		exit(1)
+	}
+
+	n := *parallel
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan bool, n)
+	results := make([]transferResult, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, n node) {
+			defer wg.Done()
+			sem <- true
+			results[i] = transferHost(n)
+			<-sem
+		}(i, t)
+	}
+	wg.Wait()
+
+	if printResults(results) > 0 {
+		exit(1)
+	}
+}
+
+func transferHost(n node) transferResult {
+	start := time.Now()
+	host := n.node.Name
+	r := transferResult{host: host}
+	args, err := transferArgs(n)
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	out := &prefixWriter{prefix: host, w: os.Stdout}
+	out.line(fmt.Sprintf("%s started", args[0]))
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// scp doesn't show progress when its output isn't a terminal
+	var stop chan bool
+	if args[0] == "scp" {
+		stop = make(chan bool)
+		go scpProgress(out, start, stop)
+	}
+	r.err = cmd.Run()
+	if stop != nil {
+		close(stop)
+	}
+	out.Flush()
+	r.dur = time.Since(start)
+	if r.err != nil {
+		out.line(fmt.Sprintf("failed: %s", r.err))
+	} else {
+		out.line(fmt.Sprintf("done in %s", r.dur))
+	}
+	return r
+}
+
+// scpProgress says how long a copy has been going every
+// scpProgressEvery until stop is closed.
+func scpProgress(out *prefixWriter, start time.Time, stop chan bool) {
+	t := time.NewTicker(scpProgressEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			out.line(fmt.Sprintf("still copying after %s", time.Since(start).Round(time.Second)))
+		case <-stop:
+			return
+		}
+	}
+}
+
+// printResults writes a summary table and returns the number of hosts
+// that failed.
+func printResults(results []transferResult) int {
+	var failed int
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "\nHOST\tSTATUS\tTIME")
+	for _, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = fmt.Sprintf("failed (%s)", r.err)
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.host, status, r.dur.Round(time.Millisecond))
+	}
+	w.Flush()
+	fmt.Printf("%d succeeded, %d failed\n", len(results)-failed, failed)
+	return failed
+}
+
+func transferArgs(n node) ([]string, error) {
+	if len(*pull) > 0 {
+		return pullArgs(n)
+	}
+
+	remote := fmt.Sprintf("%s@%s:%s", username, address(n), *dest)
+	if *useRsync {
+		return append(rsyncArgs(n), *scp, remote), nil
+	}
+
+	args := append([]string{"scp"}, scpArgs(n)...)
+	if isDir(*scp) {
+		args = append(args, "-r")
+	}
+	return append(args, *scp, remote), nil
+}
+
+// pullArgs copies the remote path into <dest>/<host>/ so files
+// with the same name on different hosts don't clobber each other.
+func pullArgs(n node) ([]string, error) {
+	host := n.node.Name
+	local := *dest
+	if local == "" {
+		local = "."
+	}
+	dir := filepath.Join(local, host)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	remote := fmt.Sprintf("%s@%s:%s", username, address(n), *pull)
+	if *useRsync {
+		return append(rsyncArgs(n), remote, dir+string(filepath.Separator)), nil
+	}
+	args := append([]string{"scp"}, scpArgs(n)...)
+	return append(args, "-r", remote, dir), nil
+}
+
+func rsyncArgs(n node) []string {
+	args := []string{"rsync", "-az", "--progress"}
+	if opts := sshArgs(n); len(opts) > 0 {
+		args = append(args, "-e", "ssh "+shellJoin(opts))
+	}
+	return args
+}
+
+func isDir(pth string) bool {
+	fi, err := os.Stat(pth)
+	return err == nil && fi.IsDir()
+}
+
+// prefixWriter tags every line of a command's output with the host it
+// came from so the output of concurrent transfers can be told apart.
+// rsync redraws its progress with \r, those updates are only passed on
+// once a second per host so they don't flood the terminal.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	buf    []byte
+	last   time.Time
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	for _, c := range b {
+		switch c {
+		case '\n':
+			p.line(string(p.buf))
+			p.buf = p.buf[:0]
+		case '\r':
+			if time.Since(p.last) > time.Second && len(bytes.TrimSpace(p.buf)) > 0 {
+				p.line(string(p.buf))
+				p.last = time.Now()
+			}
+			p.buf = p.buf[:0]
+		default:
+			p.buf = append(p.buf, c)
+		}
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.line(string(p.buf))
+		p.buf = p.buf[:0]
+	}
+}
+
+func (p *prefixWriter) line(s string) {
+	stdoutLock.Lock()
+	fmt.Fprintf(p.w, "%s: %s\n", p.prefix, s)
+	stdoutLock.Unlock()
+}
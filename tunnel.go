@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	ui "github.com/jroimartin/gocui"
+)
+
+var (
+	tunnels    []*tunnel
+	tunnelLock sync.Mutex
+)
+
+type tunnel struct {
+	host     string
+	forwards []string
+	cmd      *exec.Cmd
+	stderr   bytes.Buffer
+	running  bool
+	stopped  bool
+}
+
+// tunnelPresets are the forwards from the config for all of n's roles.
+func tunnelPresets(n node) []string {
+	var out []string
+	for _, r := range n.node.Info.Roles {
+		out = append(out, cfg.Tunnels[r]...)
+	}
+	return out
+}
+
+// parseForwards accepts a comma separated list of
+// local_port:host:remote_port or local_port:remote_port (which
+// forwards to localhost on the remote end).
+func parseForwards(s string) ([]string, error) {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		parts := strings.Split(f, ":")
+		switch len(parts) {
+		case 2:
+			f = fmt.Sprintf("%s:localhost:%s", parts[0], parts[1])
+		case 3:
+		default:
+			return nil, fmt.Errorf("invalid forward %s, use local_port:host:remote_port", f)
+		}
+		out = append(out, f)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no forwards given")
+	}
+	return out, nil
+}
+
+func openTunnel(g *ui.Gui, v *ui.View) error {
+	_, cur := v.Cursor()
+	if cur >= len(visibleNodes) {
+		return nil
+	}
+	n := visibleNodes[cur]
+	return prompt(g, fmt.Sprintf("forward ports to %s (local:host:remote, comma separated)", n.node.Name), strings.Join(tunnelPresets(n), ","), func(s string) error {
+		forwards, err := parseForwards(s)
+		if err != nil {
+			go func() { msg <- err.Error() }()
+			return nil
+		}
+		if err := startTunnel(n, forwards); err != nil {
+			go func() { msg <- err.Error() }()
+			return nil
+		}
+		go func() { msg <- fmt.Sprintf("forwarding %s to %s", strings.Join(forwards, ","), n.node.Name) }()
+		return nil
+	})
+}
+
+func startTunnel(n node, forwards []string) error {
+	t := &tunnel{host: n.node.Name, forwards: forwards}
+	args := []string{"-N", "-o", "ExitOnForwardFailure=yes"}
+	for _, f := range forwards {
+		args = append(args, "-L", f)
+	}
+	args = append(args, sshArgs(n)...)
+	args = append(args, fmt.Sprintf("%s@%s", username, address(n)))
+	t.cmd = exec.Command("ssh", args...)
+	t.cmd.Stderr = &t.stderr
+	if err := t.cmd.Start(); err != nil {
+		return err
+	}
+
+	tunnelLock.Lock()
+	t.running = true
+	tunnels = append(tunnels, t)
+	tunnelLock.Unlock()
+
+	go func() {
+		err := t.cmd.Wait()
+		tunnelLock.Lock()
+		t.running = false
+		stopped := t.stopped
+		tunnelLock.Unlock()
+		if err != nil && !stopped {
+			msg <- fmt.Sprintf("tunnel to %s exited: %s", t.host, lastLine(t.stderr.String(), err))
+		}
+		g.Execute(func(g *ui.Gui) error {
+			printTunnels()
+			return nil
+		})
+	}()
+	return nil
+}
+
+func lastLine(s string, err error) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if l := lines[len(lines)-1]; l != "" {
+		return l
+	}
+	return err.Error()
+}
+
+func stopTunnels() {
+	tunnelLock.Lock()
+	defer tunnelLock.Unlock()
+	for _, t := range tunnels {
+		if t.running {
+			t.stopped = true
+			t.cmd.Process.Kill()
+		}
+	}
+}
+
+func showTunnels(g *ui.Gui, v *ui.View) error {
+	x, y := g.Size()
+	width := getWidth()
+	if v, err := g.SetView("tunnels", width+13, 0, x, y-1); err != nil {
+		if err != ui.ErrUnknownView {
+			return err
+		}
+		v.Highlight = true
+		v.Editable = false
+		v.Title = "tunnels (x: stop, q: close)"
+	}
+	current = "tunnels"
+	printTunnels()
+	return g.SetCurrentView("tunnels")
+}
+
+func printTunnels() {
+	v, err := g.View("tunnels")
+	if err != nil {
+		return
+	}
+	v.Clear()
+	tunnelLock.Lock()
+	defer tunnelLock.Unlock()
+	if len(tunnels) == 0 {
+		fmt.Fprintln(v, "no tunnels, type 't' on a host to open one")
+		return
+	}
+	for _, t := range tunnels {
+		state := "running"
+		if t.stopped {
+			state = "stopped"
+		} else if !t.running {
+			state = "failed"
+		}
+		fmt.Fprintf(v, "%-8s %s %s\n", state, t.host, strings.Join(t.forwards, ","))
+	}
+}
+
+func stopTunnel(g *ui.Gui, v *ui.View) error {
+	_, cy := v.Cursor()
+	tunnelLock.Lock()
+	defer tunnelLock.Unlock()
+	if cy >= len(tunnels) || !tunnels[cy].running {
+		return nil
+	}
+	tunnels[cy].stopped = true
+	return tunnels[cy].cmd.Process.Kill()
+}
+
+func exitTunnels(g *ui.Gui, v *ui.View) error {
+	current = "hosts-cursor"
+	return g.DeleteView("tunnels")
+}